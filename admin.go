@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ReadOnlyRequest defines the structure for toggling read-only mode
+type ReadOnlyRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+// ReadOnlyResponse defines the structure for read-only mode status responses
+type ReadOnlyResponse struct {
+	ReadOnly bool `json:"read_only"`
+}
+
+// readOnly rejects mutating requests while set, e.g. during a database write incident
+var readOnly atomic.Bool
+
+// rejectWritesWhenReadOnly returns 503 for mutating requests while read-only mode is on
+func rejectWritesWhenReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if readOnly.Load() && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Service is temporarily read-only", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleReadOnly reports or toggles read-only mode; it requires the ADMIN_TOKEN bearer token
+func handleReadOnly(w http.ResponseWriter, r *http.Request) {
+	if !authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if !validateContentType(r.Header.Get("Content-Type")) {
+			http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		var req ReadOnlyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		readOnly.Store(req.Enabled)
+		log.Printf("Read-only mode set to %v", req.Enabled)
+	default:
+		methodNotAllowed(w, r, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReadOnlyResponse{ReadOnly: readOnly.Load()})
+}
+
+// authorizeAdmin checks the request's bearer token against ADMIN_TOKEN; admin endpoints are disabled when it is unset
+func authorizeAdmin(r *http.Request) bool {
+	adminToken := os.Getenv("ADMIN_TOKEN")
+	if adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
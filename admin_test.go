@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRejectWritesWhenReadOnly(t *testing.T) {
+	handler := rejectWritesWhenReadOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	readOnly.Store(true)
+	t.Cleanup(func() { readOnly.Store(false) })
+
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusServiceUnavailable},
+		{http.MethodPut, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/api/payments", nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.method, rec.Code, tt.want)
+		}
+	}
+
+	readOnly.Store(false)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/payments", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("writes should pass once read-only mode is off, got %d", rec.Code)
+	}
+}
+
+func TestHandleReadOnly(t *testing.T) {
+	t.Setenv("ADMIN_TOKEN", "admin-secret")
+	t.Cleanup(func() { readOnly.Store(false) })
+
+	toggle := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/read-only", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		handleReadOnly(rec, req)
+		return rec
+	}
+
+	if rec := toggle("wrong", `{"enabled":true}`); rec.Code != http.StatusUnauthorized || readOnly.Load() {
+		t.Errorf("wrong token: status = %d, read-only = %v", rec.Code, readOnly.Load())
+	}
+	if rec := toggle("admin-secret", `{"enabled":true}`); rec.Code != http.StatusOK || !readOnly.Load() {
+		t.Errorf("enable: status = %d, read-only = %v", rec.Code, readOnly.Load())
+	}
+	if rec := toggle("admin-secret", `{"enabled":false}`); rec.Code != http.StatusOK || readOnly.Load() {
+		t.Errorf("disable: status = %d, read-only = %v", rec.Code, readOnly.Load())
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Cents is a monetary amount in integer minor units. It is encoded in JSON as a decimal number
+// so the wire format stays e.g. 19.99, while arithmetic and storage avoid float rounding.
+type Cents int64
+
+var (
+	errInvalidAmount   = errors.New("invalid amount")
+	errAmountPrecision = errors.New("amount has more than two decimal places")
+)
+
+// parseCents converts a non-negative decimal amount such as "19.99" to cents
+func parseCents(amount string) (Cents, error) {
+	if matched, _ := regexp.MatchString(`^\d+\.\d{3,}$`, amount); matched {
+		return 0, errAmountPrecision
+	}
+	if matched, _ := regexp.MatchString(`^\d+(\.\d{1,2})?$`, amount); !matched {
+		return 0, errInvalidAmount
+	}
+	whole, frac, _ := strings.Cut(amount, ".")
+	frac += strings.Repeat("0", 2-len(frac))
+	cents, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errInvalidAmount
+	}
+	return Cents(cents), nil
+}
+
+// String formats the amount as a decimal, e.g. 1999 as "19.99"
+func (c Cents) String() string {
+	sign := ""
+	if c < 0 {
+		sign, c = "-", -c
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, c/100, c%100)
+}
+
+// MarshalJSON encodes the amount as a decimal JSON number
+func (c Cents) MarshalJSON() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalJSON decodes a decimal JSON number with at most two decimal places
+func (c *Cents) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	negative := strings.HasPrefix(s, "-")
+	cents, err := parseCents(strings.TrimPrefix(s, "-"))
+	if err != nil {
+		return err
+	}
+	if negative {
+		cents = -cents
+	}
+	*c = cents
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestParseCents(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Cents
+		wantErr error
+	}{
+		{"0", 0, nil},
+		{"19.99", 1999, nil},
+		{"0.1", 10, nil},
+		{"0.30", 30, nil},
+		{"5", 500, nil},
+		{"1.005", 0, errAmountPrecision},
+		{"0.001", 0, errAmountPrecision},
+		{"-1.00", 0, errInvalidAmount},
+		{"1e2", 0, errInvalidAmount},
+		{".50", 0, errInvalidAmount},
+		{"1.", 0, errInvalidAmount},
+		{"", 0, errInvalidAmount},
+		{"99999999999999999999", 0, errInvalidAmount},
+	}
+	for _, tt := range tests {
+		got, err := parseCents(tt.in)
+		if got != tt.want || !errors.Is(err, tt.wantErr) {
+			t.Errorf("parseCents(%q) = %d, %v; want %d, %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCentsAddExactly(t *testing.T) {
+	a, _ := parseCents("0.1")
+	b, _ := parseCents("0.2")
+	want, _ := parseCents("0.30")
+	if a+b != want || (a+b).String() != "0.30" {
+		t.Errorf("0.1 + 0.2 = %s, want 0.30", a+b)
+	}
+}
+
+func TestCentsString(t *testing.T) {
+	tests := []struct {
+		in   Cents
+		want string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1999, "19.99"},
+		{-250, "-2.50"},
+	}
+	for _, tt := range tests {
+		if got := tt.in.String(); got != tt.want {
+			t.Errorf("Cents(%d).String() = %q, want %q", int64(tt.in), got, tt.want)
+		}
+	}
+}
+
+func TestCentsMarshalJSON(t *testing.T) {
+	got, err := json.Marshal(struct {
+		Amount Cents `json:"amount"`
+	}{1999})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"amount":19.99}` {
+		t.Errorf("json = %s", got)
+	}
+}
+
+func TestCentsUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Cents
+		wantErr bool
+	}{
+		{`19.99`, 1999, false},
+		{`5`, 500, false},
+		{`-2.50`, -250, false},
+		{`1.005`, 0, true},
+		{`"19.99"`, 0, true},
+	}
+	for _, tt := range tests {
+		var got Cents
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("Unmarshal(%s) = %d, %v; want %d, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// requireSignature makes handlePayment reject bodies without a valid X-Signature, set via REQUIRE_SIGNATURE
+var requireSignature bool
+
+// apiKey is a configured client key, identified by its SHA-256 hash so raw keys are not kept in memory
+type apiKey struct {
+	hash                      [sha256.Size]byte
+	requireAmountConfirmation bool
+}
+
+// apiKeys holds the keys from API_KEYS
+var apiKeys []*apiKey
+
+// internalSigningKey signs the links and cursors the server hands out, set via INTERNAL_SIGNING_KEY.
+// Unlike SECRET_KEY it is never shared with clients or webhook receivers, so they cannot mint their own.
+var internalSigningKey string
+
+// amountConfirmationSecret is shared with integrations that sign the displayed amount, set via AMOUNT_CONFIRMATION_SECRET
+var amountConfirmationSecret string
+
+type apiKeyContextKey struct{}
+
+// loadAPIKeys hashes the comma-separated keys from API_KEYS. Keys also listed in confirmationKeys
+// must send an amount_confirmation with each payment.
+func loadAPIKeys(keys, confirmationKeys string) {
+	requireConfirmation := make(map[[sha256.Size]byte]bool)
+	for _, key := range strings.Split(confirmationKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			requireConfirmation[sha256.Sum256([]byte(key))] = true
+		}
+	}
+	apiKeys = nil
+	for _, key := range strings.Split(keys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			hash := sha256.Sum256([]byte(key))
+			apiKeys = append(apiKeys, &apiKey{hash: hash, requireAmountConfirmation: requireConfirmation[hash]})
+		}
+	}
+}
+
+// hexHash identifies the key in stored data, such as the scope of an idempotency key. It is empty for a nil key.
+func (k *apiKey) hexHash() string {
+	if k == nil {
+		return ""
+	}
+	return hex.EncodeToString(k.hash[:])
+}
+
+// requireAPIKey rejects requests without a valid API key with 401 before the handler reads the body,
+// and makes the matched key available to the handler via apiKeyFromContext
+func requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := validateAPIKey(apiKeyFromRequest(r))
+		if key == nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+// apiKeyFromContext returns the key that authenticated the request, or nil on unauthenticated routes
+func apiKeyFromContext(ctx context.Context) *apiKey {
+	key, _ := ctx.Value(apiKeyContextKey{}).(*apiKey)
+	return key
+}
+
+// apiKeyFromRequest reads the key from "Authorization: Bearer <key>" or the X-API-Key header
+func apiKeyFromRequest(r *http.Request) string {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// validateAPIKey compares the key's hash against every configured hash in constant time, returning the match or nil
+func validateAPIKey(key string) *apiKey {
+	if key == "" {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(key))
+	var match *apiKey
+	for _, k := range apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash[:]) == 1 {
+			match = k
+		}
+	}
+	return match
+}
+
+// signBody returns the hex HMAC-SHA256 of a raw body keyed by SECRET_KEY, as carried in X-Signature
+func signBody(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("SECRET_KEY")))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateSignature checks an X-Signature header against the body in constant time
+func validateSignature(body []byte, signature string) bool {
+	if signature == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(signature)), []byte(signBody(body))) == 1
+}
+
+// validateAmountConfirmation checks the client's hex HMAC-SHA256 of "<amount>:<currency>", keyed by
+// AMOUNT_CONFIRMATION_SECRET, against the amount being charged
+func validateAmountConfirmation(amount Cents, currency, confirmation string) bool {
+	if confirmation == "" || amountConfirmationSecret == "" {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(amountConfirmationSecret))
+	mac.Write([]byte(amount.String() + ":" + currency))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(strings.ToLower(confirmation)), []byte(expected)) == 1
+}
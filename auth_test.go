@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireAPIKey(t *testing.T) {
+	loadAPIKeys("key-one, key-two", "")
+	t.Cleanup(func() { apiKeys = nil })
+
+	var gotKey *apiKey
+	handler := requireAPIKey(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey = apiKeyFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"missing", nil, http.StatusUnauthorized},
+		{"invalid bearer", map[string]string{"Authorization": "Bearer nope"}, http.StatusUnauthorized},
+		{"invalid header", map[string]string{"X-API-Key": "nope"}, http.StatusUnauthorized},
+		{"basic scheme", map[string]string{"Authorization": "Basic key-one"}, http.StatusUnauthorized},
+		{"bearer", map[string]string{"Authorization": "Bearer key-one"}, http.StatusOK},
+		{"x-api-key", map[string]string{"X-API-Key": "key-two"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey = nil
+			req := httptest.NewRequest(http.MethodPost, "/api/payments", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized {
+				if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+					t.Error("missing WWW-Authenticate challenge")
+				}
+				return
+			}
+			if gotKey == nil {
+				t.Error("matched key not passed to the handler")
+			}
+		})
+	}
+}
+
+func TestLoadAPIKeysAmountConfirmation(t *testing.T) {
+	loadAPIKeys("plain,confirmed", "confirmed")
+	t.Cleanup(func() { apiKeys = nil })
+
+	if key := validateAPIKey("plain"); key == nil || key.requireAmountConfirmation {
+		t.Errorf("plain key = %+v", key)
+	}
+	if key := validateAPIKey("confirmed"); key == nil || !key.requireAmountConfirmation {
+		t.Errorf("confirmed key = %+v", key)
+	}
+	if validateAPIKey("") != nil {
+		t.Error("an empty key should never match")
+	}
+}
+
+func TestValidateSignature(t *testing.T) {
+	setTestSecrets(t)
+	body := []byte(`{"amount":10.00}`)
+	signature := signBody(body)
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"correct", body, signature, true},
+		{"uppercase hex", body, strings.ToUpper(signature), true},
+		{"altered body", []byte(`{"amount":99.00}`), signature, false},
+		{"wrong signature", body, strings.Repeat("0", len(signature)), false},
+		{"missing", body, "", false},
+	}
+	for _, tt := range tests {
+		if got := validateSignature(tt.body, tt.signature); got != tt.want {
+			t.Errorf("%s: validateSignature() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentRequiresSignature(t *testing.T) {
+	setTestSecrets(t)
+	requireSignature = true
+	t.Cleanup(func() { requireSignature = false })
+	body := `{}`
+
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{"missing", "", http.StatusUnauthorized},
+		{"incorrect", signBody([]byte(`{"other":1}`)), http.StatusUnauthorized},
+		// A correct signature gets past the check to validation of the empty payment
+		{"correct", signBody([]byte(body)), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		rec := postPayment(t, body, map[string]string{"X-Signature": tt.signature})
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestValidateAmountConfirmation(t *testing.T) {
+	amountConfirmationSecret = "confirm-secret"
+	t.Cleanup(func() { amountConfirmationSecret = "" })
+	confirmation := hex.EncodeToString(hmacSHA256("confirm-secret", "10.00:USD"))
+
+	tests := []struct {
+		name         string
+		amount       Cents
+		currency     string
+		confirmation string
+		want         bool
+	}{
+		{"match", 1000, "USD", confirmation, true},
+		{"uppercase hex", 1000, "USD", strings.ToUpper(confirmation), true},
+		{"amount changed", 1, "USD", confirmation, false},
+		{"currency changed", 1000, "EUR", confirmation, false},
+		{"missing", 1000, "USD", "", false},
+	}
+	for _, tt := range tests {
+		if got := validateAmountConfirmation(tt.amount, tt.currency, tt.confirmation); got != tt.want {
+			t.Errorf("%s: validateAmountConfirmation() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentAmountConfirmation(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	loadAPIKeys("confirmed", "confirmed")
+	amountConfirmationSecret = "confirm-secret"
+	t.Cleanup(func() {
+		apiKeys = nil
+		amountConfirmationSecret = ""
+	})
+	handler := requireAPIKey(http.HandlerFunc(handlePayment))
+	confirmation := hex.EncodeToString(hmacSHA256("confirm-secret", "10.00:USD"))
+
+	tests := []struct {
+		name         string
+		amount       string
+		confirmation string
+		want         int
+	}{
+		{"match", "10.00", confirmation, http.StatusOK},
+		{"tampered amount", "1.00", confirmation, http.StatusBadRequest},
+		{"missing", "10.00", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":` + tt.amount + `,"amount_confirmation":"` + tt.confirmation + `"}`
+		req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "confirmed")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d, body %s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
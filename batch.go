@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxBatchSize      = 100
+	maxBatchBodyBytes = maxBatchSize * maxPaymentBodyBytes
+)
+
+// BatchPaymentResult defines the outcome of one item in a batch, in the same order as the request
+type BatchPaymentResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"` // success, failed, invalid or error
+	PaymentResponse
+}
+
+// handleBatchPayment processes an array of payments independently; an invalid or failed item does not abort the rest
+func handleBatchPayment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	reqID := requestID(w, r)
+
+	if until, active := maintenance.activeUntil(time.Now()); active {
+		rejectForMaintenance(w, until)
+		return
+	}
+
+	if ipReputation.blocks(r.Context(), clientIP(r)) {
+		http.Error(w, "Payment blocked: ip_blocked", http.StatusForbidden)
+		return
+	}
+
+	if !validateContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, ok := readPaymentBody(w, r, maxBatchBodyBytes)
+	if !ok {
+		return
+	}
+
+	var reqs []PaymentRequest
+	err := json.Unmarshal(body, &reqs)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if len(reqs) == 0 {
+		http.Error(w, "Batch must contain at least one payment", http.StatusBadRequest)
+		return
+	}
+	if limit := batchLimit(); len(reqs) > limit {
+		http.Error(w, "Batch exceeds the maximum of "+strconv.Itoa(limit)+" payments", http.StatusRequestEntityTooLarge)
+		return
+	}
+	// Each item counts against the client's rate limit like a single payment. The middleware
+	// already charged one token for the request itself.
+	if ok, retryAfter := paymentLimiter.allowN(clientIP(r), len(reqs)-1, time.Now()); !ok {
+		rateLimited(w, retryAfter)
+		return
+	}
+
+	userAgent := sanitizeUserAgent(r.UserAgent())
+	key := apiKeyFromContext(r.Context())
+
+	results := make([]BatchPaymentResult, 0, len(reqs))
+	for i := range reqs {
+		// Items already charged are stored, but the rest are not charged for a client that has gone away
+		if errors.Is(r.Context().Err(), context.Canceled) {
+			slog.Info("Batch abandoned: client disconnected", "processed", i, "request_id", reqID)
+			return
+		}
+		result := processBatchItem(r.Context(), &reqs[i], key, userAgent, reqID)
+		result.Index = i
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// batchLimit is the largest batch that can be admitted: maxBatchSize, or RATE_LIMIT when that is lower,
+// since every item takes a rate limit token
+func batchLimit() int {
+	if paymentLimiter != nil {
+		return min(maxBatchSize, paymentLimiter.limit)
+	}
+	return maxBatchSize
+}
+
+// processBatchItem validates, tokenizes, charges and stores a single batch item
+func processBatchItem(ctx context.Context, req *PaymentRequest, key *apiKey, userAgent, reqID string) BatchPaymentResult {
+	brand := DetectBrand(req.CardNumber)
+	amount, currency, rejection := validatePayment(req, brand, key)
+	if rejection != nil {
+		metrics.recordRejection(rejection.reason)
+		return BatchPaymentResult{Status: "invalid", PaymentResponse: PaymentResponse{Message: rejection.message}}
+	}
+
+	txn := &Transaction{
+		Token:             tokenizeCard(req.CardNumber),
+		Amount:            amount,
+		UserAgent:         userAgent,
+		DeviceFingerprint: req.DeviceFingerprint,
+		Last4:             cardLast4(req.CardNumber),
+		Currency:          currency,
+		TaxID:             req.TaxID,
+		TaxIDCountry:      req.TaxIDCountry,
+		BillingCountry:    req.BillingCountry,
+		GeoMismatch:       geoMismatch(req.CardNumber, req.BillingCountry),
+		Tags:              req.Tags,
+		MCC:               req.MCC,
+	}
+	transactionID, success, err := processAndStorePayment(ctx, txn, req.Expiry, req.CVV, "")
+	switch {
+	case isDBTimeout(err):
+		return BatchPaymentResult{Status: "error", PaymentResponse: PaymentResponse{Message: "Database timeout"}}
+	case errors.Is(err, errProcessorUnavailable):
+		slog.Error("Payment processor unavailable", "card", maskCard(req.CardNumber), "error", err.Error(), "request_id", reqID)
+		return BatchPaymentResult{Status: "error", PaymentResponse: PaymentResponse{Message: "Payment processor unavailable"}}
+	case err != nil:
+		return BatchPaymentResult{Status: "error", PaymentResponse: PaymentResponse{Message: "Failed to process payment"}}
+	}
+
+	slog.Info("Payment processed",
+		"card", maskCard(req.CardNumber),
+		"amount", amount.String(),
+		"currency", currency,
+		"success", success,
+		"geo_mismatch", txn.GeoMismatch,
+		"transaction_id", transactionID,
+		"request_id", reqID,
+		"batch", true,
+	)
+	metrics.recordPayment(success)
+	sendPaymentWebhook(txn, success)
+
+	status := "failed"
+	if success {
+		status = "success"
+	}
+	return BatchPaymentResult{Status: status, PaymentResponse: newPaymentResponse(txn, success, brand)}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postBatch sends body to handleBatchPayment
+func postBatch(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/payments/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handleBatchPayment(rec, req)
+	return rec
+}
+
+// batchOf returns a JSON array of n copies of item
+func batchOf(item string, n int) string {
+	return "[" + strings.TrimSuffix(strings.Repeat(item+",", n), ",") + "]"
+}
+
+func TestBatchRejectsEmptyAndOversized(t *testing.T) {
+	setTestSecrets(t)
+	item := testPaymentBody("4242424242424242", "1.00")
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"empty", `[]`, http.StatusBadRequest},
+		{"not an array", item, http.StatusBadRequest},
+		{"too many items", batchOf(item, maxBatchSize+1), http.StatusRequestEntityTooLarge},
+		{"body too large", `[{"card_number":"` + strings.Repeat("4", maxBatchBodyBytes) + `"}]`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postBatch(tt.body); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentBodyTooLarge(t *testing.T) {
+	setTestSecrets(t)
+	rec := postPayment(t, `{"card_number":"`+strings.Repeat("4", maxPaymentBodyBytes)+`"}`, nil)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d, want 413", rec.Code)
+	}
+}
+
+func TestBatchChargesRateLimitPerItem(t *testing.T) {
+	setTestSecrets(t)
+	paymentLimiter = newRateLimiter(3, time.Minute)
+	t.Cleanup(func() { paymentLimiter = nil })
+	handler := paymentLimiter.middleware(http.HandlerFunc(handleBatchPayment))
+	// Invalid items are rejected before reaching the database but still cost a token
+	item := `{"card_number":"1234"}`
+
+	post := func(n int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/payments/batch", strings.NewReader(batchOf(item, n)))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := post(4); rec.Code != http.StatusRequestEntityTooLarge || !strings.Contains(rec.Body.String(), "maximum of 3 payments") {
+		t.Errorf("batch larger than the rate limit: status = %d, body %q; want 413 naming the limit of 3", rec.Code, rec.Body)
+	}
+	if rec := post(2); rec.Code != http.StatusOK {
+		t.Fatalf("batch within the limit: status = %d, body %s", rec.Code, rec.Body)
+	}
+	rec := post(2)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("batch over the remaining budget: status = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+}
+
+func TestBatchMixedResults(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	body := "[" + strings.Join([]string{
+		testPaymentBody("4242424242424242", "10.00"),
+		`{"card_number":"1234","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00}`,
+		testPaymentBody("378282246310005", "25.50"),
+	}, ",") + "]"
+
+	rec := postBatch(body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var results []BatchPaymentResult
+	if err := json.NewDecoder(rec.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		status string
+		txnID  int
+	}{{"success", 1}, {"invalid", 0}, {"success", 2}}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, w := range want {
+		if results[i].Index != i || results[i].Status != w.status || results[i].TransactionID != w.txnID {
+			t.Errorf("result %d = %+v, want status %s transaction %d", i, results[i], w.status, w.txnID)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// binBrandOverrides maps BIN prefixes to brands, correcting the built-in ranges in DetectBrand
+var binBrandOverrides map[string]string
+
+// binCountries maps BIN prefixes to the issuing country's ISO 3166-1 alpha-2 code
+var binCountries map[string]string
+
+// loadBINBrandOverrides parses BIN_BRAND_OVERRIDES, a comma-separated list of prefix=brand pairs such as "222100=mastercard"
+func loadBINBrandOverrides(overrides string) (map[string]string, error) {
+	return parseBINTable(overrides, func(brand string) (string, bool) {
+		brand = strings.ToLower(brand)
+		return brand, brand != ""
+	})
+}
+
+// loadBINCountries parses BIN_COUNTRIES, a comma-separated list of prefix=country pairs such as "457173=DK"
+func loadBINCountries(countries string) (map[string]string, error) {
+	return parseBINTable(countries, func(country string) (string, bool) {
+		country, ok := normalizeCountry(country)
+		return country, ok && country != ""
+	})
+}
+
+// parseBINTable parses a comma-separated list of prefix=value pairs with prefixes of up to 8 digits,
+// normalizing each value and rejecting the entry when normalize reports false
+func parseBINTable(entries string, normalize func(string) (string, bool)) (map[string]string, error) {
+	table := make(map[string]string)
+	for _, entry := range strings.Split(entries, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSpace(prefix)
+		value, valid := normalize(strings.TrimSpace(value))
+		if !ok || !valid || len(prefix) == 0 || len(prefix) > 8 || strings.Trim(prefix, "0123456789") != "" {
+			return nil, errors.New("invalid BIN entry: " + entry)
+		}
+		table[prefix] = value
+	}
+	return table, nil
+}
+
+// lookupBIN returns the table's entry for the longest prefix of cardNumber, along with that prefix
+func lookupBIN(table map[string]string, cardNumber string) (prefix, value string, ok bool) {
+	for n := min(len(cardNumber), 8); n > 0; n-- {
+		if value, ok := table[cardNumber[:n]]; ok {
+			return cardNumber[:n], value, true
+		}
+	}
+	return "", "", false
+}
+
+// binBrandOverride returns the brand for the longest overridden prefix of cardNumber, if any.
+// Applied overrides are counted by their configured prefix; no card digits are logged.
+func binBrandOverride(cardNumber string) (string, bool) {
+	prefix, brand, ok := lookupBIN(binBrandOverrides, cardNumber)
+	if ok {
+		slog.Debug("BIN override applied", "prefix", prefix, "brand", brand)
+		metrics.recordBINOverride(prefix, brand)
+	}
+	return brand, ok
+}
+
+// binCountry returns the issuing country for cardNumber, or "" when its BIN is not in BIN_COUNTRIES
+func binCountry(cardNumber string) string {
+	_, country, _ := lookupBIN(binCountries, normalizeCardNumber(cardNumber))
+	return country
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestLoadBINBrandOverrides(t *testing.T) {
+	got, err := loadBINBrandOverrides(" 222100=MasterCard, 4571=dankort ,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"222100": "mastercard", "4571": "dankort"}; !maps.Equal(got, want) {
+		t.Errorf("overrides = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"222100", "=visa", "22x1=visa", "123456789=visa", "4571="} {
+		if _, err := loadBINBrandOverrides(bad); err == nil {
+			t.Errorf("loadBINBrandOverrides(%q) should fail", bad)
+		}
+	}
+}
+
+func TestLoadBINCountries(t *testing.T) {
+	got, err := loadBINCountries("457173=dk, 424242=US")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"457173": "DK", "424242": "US"}; !maps.Equal(got, want) {
+		t.Errorf("countries = %v, want %v", got, want)
+	}
+
+	for _, bad := range []string{"457173", "457173=", "457173=ZZ", "457173=DNK", "45x1=DK"} {
+		if _, err := loadBINCountries(bad); err == nil {
+			t.Errorf("loadBINCountries(%q) should fail", bad)
+		}
+	}
+}
+
+func TestDetectBrandWithOverrides(t *testing.T) {
+	binBrandOverrides = map[string]string{"4571": "dankort", "457199": "visa-debit"}
+	t.Cleanup(func() { binBrandOverrides = nil })
+
+	tests := []struct {
+		card string
+		want string
+	}{
+		{"4571000000000008", "dankort"},
+		{"4571990000000009", "visa-debit"}, // longest prefix wins
+		{"4242424242424242", "visa"},       // unaffected ranges use built-in detection
+		{"378282246310005", "amex"},
+	}
+	for _, tt := range tests {
+		if got := DetectBrand(tt.card); got != tt.want {
+			t.Errorf("DetectBrand(%q) = %q, want %q", tt.card, got, tt.want)
+		}
+	}
+}
+
+func TestBINOverrideRecordsPrefixOnly(t *testing.T) {
+	binBrandOverrides = map[string]string{"4571": "dankort"}
+	metrics = newPaymentMetrics()
+	t.Cleanup(func() {
+		binBrandOverrides = nil
+		metrics = newPaymentMetrics()
+	})
+	logs := captureLogs(t)
+
+	DetectBrand("4571990000000009")
+	if strings.Contains(logs.String(), "457199") {
+		t.Errorf("log contains card digits beyond the override prefix: %s", logs)
+	}
+	if !strings.Contains(logs.String(), `"prefix":"4571"`) {
+		t.Errorf("log does not name the override prefix: %s", logs)
+	}
+	if out := scrapeMetrics(t); !strings.Contains(out, `bin_brand_overrides_total{brand="dankort",prefix="4571"} 1`) {
+		t.Error("override not counted in metrics")
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateCardNumberSandbox(t *testing.T) {
+	t.Cleanup(func() { sandboxMode = false })
+	const failsLuhn = "4242424242424241"
+
+	sandboxMode = false
+	if validateCardNumber(failsLuhn) {
+		t.Error("production should reject a number failing the Luhn check")
+	}
+	sandboxMode = true
+	if !validateCardNumber(failsLuhn) {
+		t.Error("sandbox should skip the Luhn check")
+	}
+	if validateCardNumber("4242abcd42424242") || validateCardNumber("424242") {
+		t.Error("sandbox should still apply the digit and length checks")
+	}
+}
+
+func TestValidateCardNumberLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{"13-digit visa", "4222222222222", true},
+		{"15-digit amex", "378282246310005", true},
+		{"16-digit visa", "4242424242424242", true},
+		{"16-digit visa with spaces", "4242 4242 4242 4242", true},
+		{"19-digit", "4242424242424242428", true},
+		{"12 digits", "424242424242", false},
+		{"20 digits", "42424242424242424284", false},
+		{"fails luhn", "4242424242424241", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateCardNumber(tt.number); got != tt.want {
+				t.Errorf("validateCardNumber(%q) = %v, want %v", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectBrand(t *testing.T) {
+	tests := []struct {
+		name string
+		card string
+		want string
+	}{
+		{"visa 16 digits", "4242424242424242", "visa"},
+		{"visa 13 digits", "4222222222222", "visa"},
+		{"visa 19 digits", "4000000000000000006", "visa"},
+		{"visa 15 digits", "424242424242424", "unknown"},
+		{"whitespace stripped", "4242 4242 4242 4242", "visa"},
+		{"mastercard 51", "5105105105105100", "mastercard"},
+		{"mastercard 55", "5555555555554444", "mastercard"},
+		{"50 is not mastercard", "5000000000000000", "unknown"},
+		{"56 is not mastercard", "5600000000000000", "unknown"},
+		{"2220 below 2-series", "2220000000000000", "unknown"},
+		{"2221 starts 2-series", "2221000000000009", "mastercard"},
+		{"2720 ends 2-series", "2720990000000000", "mastercard"},
+		{"2721 above 2-series", "2721000000000000", "unknown"},
+		{"mastercard 19 digits", "5555555555554444000", "unknown"},
+		{"amex 34", "343434343434343", "amex"},
+		{"amex 37", "378282246310005", "amex"},
+		{"amex 16 digits", "3782822463100050", "unknown"},
+		{"discover 6011", "6011111111111117", "discover"},
+		{"discover 644", "6440000000000000", "discover"},
+		{"discover 65", "6500000000000002", "discover"},
+		{"discover 622126", "6221260000000000", "discover"},
+		{"discover 622925", "6229250000000000", "discover"},
+		{"622125 below discover range", "6221250000000000", "unknown"},
+		{"discover 19 digits", "6011000000000000004", "discover"},
+		{"discover 15 digits", "601111111111111", "unknown"},
+		{"unknown prefix", "9999999999999999", "unknown"},
+		{"empty", "", "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectBrand(tt.card); got != tt.want {
+				t.Errorf("DetectBrand(%q) = %q, want %q", tt.card, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCVV(t *testing.T) {
+	tests := []struct {
+		cvv   string
+		brand string
+		want  bool
+	}{
+		{"1234", "amex", true},
+		{"123", "amex", false},
+		{"123", "visa", true},
+		{"1234", "visa", false},
+		{"123", "", true},
+		{"12a", "visa", false},
+		{"", "visa", false},
+	}
+	for _, tt := range tests {
+		if got := validateCVV(tt.cvv, tt.brand); got != tt.want {
+			t.Errorf("validateCVV(%q, %q) = %v, want %v", tt.cvv, tt.brand, got, tt.want)
+		}
+	}
+}
+
+func TestValidateExpiryAt(t *testing.T) {
+	march2026 := time.Date(2026, 3, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		expiry string
+		now    time.Time
+		want   bool
+	}{
+		{"this month", "03/26", march2026, true},
+		{"last day of this month", "03/26", time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC), true},
+		{"last month", "02/26", march2026, false},
+		{"next year", "01/27", march2026, true},
+		{"2099 card in 2099", "12/99", time.Date(2099, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2100 card in 2099", "01/00", time.Date(2099, 6, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2099 card in 2100", "12/99", time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC), false},
+		{"invalid month", "13/26", march2026, false},
+		{"zero month", "00/26", march2026, false},
+		{"wrong format", "3/26", march2026, false},
+		{"four-digit year", "03/2026", march2026, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validateExpiryAt(tt.expiry, tt.now); got != tt.want {
+				t.Errorf("validateExpiryAt(%q, %v) = %v, want %v", tt.expiry, tt.now, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidateContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{"application/json", true},
+		{"application/json; charset=utf-8", true},
+		{"Application/JSON", true},
+		{"text/plain", false},
+		{"application/x-www-form-urlencoded", false},
+		{"", false},
+		{"application/json; charset", false},
+	}
+	for _, tt := range tests {
+		if got := validateContentType(tt.contentType); got != tt.want {
+			t.Errorf("validateContentType(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentRejectsUnsupportedContentType(t *testing.T) {
+	rec := postPayment(t, `{}`, map[string]string{"Content-Type": "text/plain"})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("status = %d, want 415", rec.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeCountry(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"", "", true},
+		{"US", "US", true},
+		{" de ", "DE", true},
+		{"gb", "GB", true},
+		{"UK", "UK", false},   // not an assigned code
+		{"XX", "XX", false},   // user-assigned range
+		{"USA", "USA", false}, // alpha-3
+		{"1A", "1A", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeCountry(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizeCountry(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGeoMismatch(t *testing.T) {
+	binCountries = map[string]string{"4571": "DK", "424242": "US"}
+	t.Cleanup(func() { binCountries = nil })
+
+	tests := []struct {
+		card    string
+		billing string
+		want    bool
+	}{
+		{"4242424242424242", "US", false},
+		{"4242 4242 4242 4242", "FR", true},
+		{"4571000000000008", "US", true},
+		{"4571000000000008", "", false},   // billing country unknown
+		{"5555555555554444", "FR", false}, // issuing country unknown
+	}
+	for _, tt := range tests {
+		if got := geoMismatch(tt.card, tt.billing); got != tt.want {
+			t.Errorf("geoMismatch(%q, %q) = %v, want %v", tt.card, tt.billing, got, tt.want)
+		}
+	}
+}
+
+func TestPaymentRejectsUnknownBillingCountry(t *testing.T) {
+	setTestSecrets(t)
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"billing_country":"ZZ"}`
+	rec := postPayment(t, body, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPaymentStoresBillingCountry(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	binCountries = map[string]string{"424242": "US"}
+	t.Cleanup(func() { binCountries = nil })
+
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"billing_country":"fr"}`
+	rec := postPayment(t, body, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp PaymentResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	txn, err := getTransaction(t.Context(), resp.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txn.BillingCountry != "FR" {
+		t.Errorf("stored billing_country = %q, want FR", txn.BillingCountry)
+	}
+	if !txn.GeoMismatch {
+		t.Error("a US-issued card billed to FR should be flagged as a geo mismatch")
+	}
+}
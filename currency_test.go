@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeCurrency(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"", "USD", true},
+		{"EUR", "EUR", true},
+		{"eur", "EUR", true},
+		{" gbp ", "GBP", true},
+		{"XYZ", "XYZ", false},
+		{"EURO", "EURO", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizeCurrency(tt.in)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizeCurrency(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestPaymentRejectsUnsupportedCurrency(t *testing.T) {
+	setTestSecrets(t)
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"currency":"XYZ"}`
+	if rec := postPayment(t, body, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPaymentStoresCurrency(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"currency":"eur"}`, "EUR"},
+		{testPaymentBody("4242424242424242", "10.00"), "USD"},
+	}
+	for _, tt := range tests {
+		resp := createPayment(t, tt.body, nil)
+		if resp.Currency != tt.want {
+			t.Errorf("response currency = %q, want %q", resp.Currency, tt.want)
+		}
+		txn, err := getTransaction(t.Context(), resp.TransactionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txn.Currency != tt.want {
+			t.Errorf("stored currency = %q, want %q", txn.Currency, tt.want)
+		}
+	}
+}
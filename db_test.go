@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+func TestDatabaseDSN(t *testing.T) {
+	got := databaseDSN("host=localhost sslmode=disable", 1500*time.Millisecond)
+	if want := "host=localhost sslmode=disable statement_timeout=1500"; got != want {
+		t.Errorf("databaseDSN() = %q, want %q", got, want)
+	}
+}
+
+func TestStatementTimeoutIsDBTimeout(t *testing.T) {
+	connStr := os.Getenv("TEST_DATABASE_URL")
+	if connStr == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		var err error
+		connStr, err = pq.ParseURL(connStr)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn, err := sql.Open("postgres", databaseDSN(connStr, 100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(context.Background(), "SELECT pg_sleep(1)")
+	if !isDBTimeout(err) {
+		t.Errorf("err = %v, want a statement timeout", err)
+	}
+}
+
+func TestContextDeadlineIsDBTimeout(t *testing.T) {
+	if !isDBTimeout(context.DeadlineExceeded) {
+		t.Error("context.DeadlineExceeded should count as a database timeout")
+	}
+	if isDBTimeout(sql.ErrNoRows) || isDBTimeout(nil) {
+		t.Error("other errors should not count as a database timeout")
+	}
+}
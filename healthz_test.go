@@ -0,0 +1,41 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthzWithClosedDB(t *testing.T) {
+	closed, err := sql.Open("postgres", "host=localhost dbname=unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	db = closed
+	t.Cleanup(func() { db = nil })
+
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	var resp HealthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != "unavailable" {
+		t.Errorf("status = %q, want unavailable", resp.Status)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	openTestDB(t)
+	rec := httptest.NewRecorder()
+	handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want 200", rec.Code)
+	}
+}
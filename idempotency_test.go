@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIdempotencyKeyReplaysPayment(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	headers := map[string]string{"Idempotency-Key": "order-1"}
+
+	first := createPayment(t, testPaymentBody("4242424242424242", "10.00"), headers)
+	second := createPayment(t, testPaymentBody("4242 4242 4242 4242", "10.00"), headers)
+	if second.TransactionID != first.TransactionID {
+		t.Errorf("replay returned transaction %d, want %d", second.TransactionID, first.TransactionID)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("stored %d transactions, want 1", count)
+	}
+}
+
+func TestIdempotencyKeyConflict(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	headers := map[string]string{"Idempotency-Key": "order-2"}
+	createPayment(t, testPaymentBody("4242424242424242", "10.00"), headers)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"different amount", testPaymentBody("4242424242424242", "11.00")},
+		{"different card", testPaymentBody("5555555555554444", "10.00")},
+		{"different currency", `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"currency":"EUR"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postPayment(t, tt.body, headers)
+			if rec.Code != http.StatusConflict {
+				t.Errorf("status = %d, want 409", rec.Code)
+			}
+		})
+	}
+}
+
+func TestIdempotencyKeyConcurrentRequestsChargeOnce(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	var calls atomic.Int32
+	charging, release := make(chan struct{}), make(chan struct{})
+	chargeProcessor = func(string, Cents, string) (bool, error) {
+		calls.Add(1)
+		close(charging)
+		<-release
+		return true, nil
+	}
+	t.Cleanup(func() { chargeProcessor = simulateCharge })
+	headers := map[string]string{"Idempotency-Key": "order-3"}
+	body := testPaymentBody("4242424242424242", "10.00")
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- postPayment(t, body, headers) }()
+	<-charging
+
+	rec := postPayment(t, body, headers)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("retry while in progress: status = %d, want 409", rec.Code)
+	}
+	close(release)
+	if rec := <-first; rec.Code != http.StatusOK {
+		t.Fatalf("first request: status = %d, body %s", rec.Code, rec.Body)
+	}
+	createPayment(t, body, headers)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("processor called %d times, want 1", n)
+	}
+}
+
+func TestIdempotencyKeyReleasedWhenProcessorUnavailable(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	scriptProcessor(t, errProcessorUnavailable, errProcessorUnavailable, errProcessorUnavailable, nil)
+	headers := map[string]string{"Idempotency-Key": "order-4"}
+	body := testPaymentBody("4242424242424242", "10.00")
+
+	if rec := postPayment(t, body, headers); rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want 502", rec.Code)
+	}
+	// Nothing was charged, so the retry goes to the processor instead of replaying
+	createPayment(t, body, headers)
+}
+
+func TestIdempotencyKeyScopedToAPIKey(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	loadAPIKeys("key-a,key-b", "")
+	t.Cleanup(func() { apiKeys = nil })
+	handler := requireAPIKey(http.HandlerFunc(handlePayment))
+
+	pay := func(apiKey, amount string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(testPaymentBody("4242424242424242", amount)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", apiKey)
+		req.Header.Set("Idempotency-Key", "shared-key")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if code := pay("key-a", "10.00"); code != http.StatusOK {
+		t.Fatalf("key-a: status = %d, want 200", code)
+	}
+	// Another client's payment under the same key neither conflicts with nor replays key-a's
+	if code := pay("key-b", "25.00"); code != http.StatusOK {
+		t.Fatalf("key-b: status = %d, want 200", code)
+	}
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM transactions").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("stored %d transactions, want 2", count)
+	}
+}
+
+func TestIdempotencyKeyTooLong(t *testing.T) {
+	setTestSecrets(t)
+	key := strings.Repeat("k", maxIdempotencyKeyLength+1)
+	rec := postPayment(t, testPaymentBody("4242424242424242", "10.00"), map[string]string{"Idempotency-Key": key})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// paymentLimiter rate limits the payment endpoints; the batch endpoint charges it once per item
+var paymentLimiter *rateLimiter
+
+// trustProxy makes clientIP honor X-Forwarded-For; only enable it behind a proxy that sets the header
+var trustProxy bool
+
+// inFlightLimiter caps the number of concurrent requests from a single client IP
+type inFlightLimiter struct {
+	mu     sync.Mutex
+	max    int
+	active map[string]int
+}
+
+// newInFlightLimiter creates a limiter allowing max concurrent requests per IP
+func newInFlightLimiter(max int) *inFlightLimiter {
+	return &inFlightLimiter{max: max, active: make(map[string]int)}
+}
+
+// acquire reserves a slot for ip, returning false when it is already at the cap
+func (l *inFlightLimiter) acquire(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.active[ip] >= l.max {
+		return false
+	}
+	l.active[ip]++
+	return true
+}
+
+// release frees a slot for ip, dropping the entry once it has no requests in flight
+func (l *inFlightLimiter) release(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[ip]--
+	if l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+}
+
+// middleware rejects requests beyond the per-IP cap with 429
+func (l *inFlightLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := clientIP(r)
+		if !l.acquire(ip) {
+			http.Error(w, "Too many concurrent requests", http.StatusTooManyRequests)
+			return
+		}
+		defer l.release(ip)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimiter is a per-IP token bucket: each client can burst up to limit requests, and its
+// bucket refills continuously at limit tokens per window. Unlike a fixed window, this never
+// admits more than limit requests around a window boundary.
+type rateLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	clients map[string]*tokenBucket
+}
+
+// tokenBucket tracks one client's remaining tokens as of last
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter creates a limiter allowing bursts of limit requests per IP, refilled at limit per window
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, clients: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token for a request from ip at now, returning how long to wait when the bucket is empty
+func (l *rateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	return l.allowN(ip, 1, now)
+}
+
+// allowN takes n tokens from ip's bucket at once, or none when fewer than n are left. A nil limiter allows everything.
+func (l *rateLimiter) allowN(ip string, n int, now time.Time) (bool, time.Duration) {
+	if l == nil || n <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	perSecond := float64(l.limit) / l.window.Seconds()
+	b, ok := l.clients[ip]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.limit), last: now}
+		l.clients[ip] = b
+	}
+	if now.After(b.last) {
+		b.tokens = min(float64(l.limit), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+		b.last = now
+	}
+	if b.tokens < float64(n) {
+		return false, time.Duration((float64(n) - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens -= float64(n)
+	return true, 0
+}
+
+// cleanup drops clients whose bucket has had a full window to refill, since a new bucket starts full anyway
+func (l *rateLimiter) cleanup(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ip, b := range l.clients {
+		if now.Sub(b.last) >= l.window {
+			delete(l.clients, ip)
+		}
+	}
+}
+
+// startCleanup runs cleanup once per window for the life of the process
+func (l *rateLimiter) startCleanup() {
+	go func() {
+		ticker := time.NewTicker(l.window)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			l.cleanup(now)
+		}
+	}()
+}
+
+// middleware rejects requests beyond the per-IP rate with 429 and a Retry-After header
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, retryAfter := l.allow(clientIP(r), time.Now())
+		if !ok {
+			rateLimited(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimited writes a 429 telling the client to retry after retryAfter, rounded up to whole seconds
+func rateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+}
+
+// clientIP returns the source IP of the request. Behind a trusted proxy it uses the
+// last X-Forwarded-For entry, which the proxy appended and the client cannot spoof.
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			parts := strings.Split(forwarded, ",")
+			if ip := strings.TrimSpace(parts[len(parts)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
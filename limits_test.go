@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInFlightLimiter(t *testing.T) {
+	l := newInFlightLimiter(2)
+	if !l.acquire("10.0.0.1") || !l.acquire("10.0.0.1") {
+		t.Fatal("requests up to the cap should be admitted")
+	}
+	if l.acquire("10.0.0.1") {
+		t.Error("a request beyond the cap should be rejected")
+	}
+	if !l.acquire("10.0.0.2") {
+		t.Error("another IP should have its own cap")
+	}
+	l.release("10.0.0.1")
+	if !l.acquire("10.0.0.1") {
+		t.Error("a released slot should be reusable")
+	}
+}
+
+func TestInFlightLimiterMiddleware(t *testing.T) {
+	l := newInFlightLimiter(1)
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-unblock
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+	close(unblock)
+	<-done
+	if len(l.active) != 0 {
+		t.Errorf("active = %v, want empty after the request finishes", l.active)
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	l := newRateLimiter(3, time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		if ok, _ := l.allow("10.0.0.1", start); !ok {
+			t.Fatalf("request %d within the burst was rejected", i+1)
+		}
+	}
+	ok, retryAfter := l.allow("10.0.0.1", start)
+	if ok {
+		t.Fatal("request beyond the burst was admitted")
+	}
+	if retryAfter != 20*time.Second {
+		t.Errorf("retry after = %v, want 20s for one token at 3/min", retryAfter)
+	}
+	if ok, _ := l.allow("10.0.0.2", start); !ok {
+		t.Error("another IP should have its own bucket")
+	}
+	if ok, _ := l.allow("10.0.0.1", start.Add(20*time.Second)); !ok {
+		t.Error("a refilled token should be usable")
+	}
+	if ok, _ := l.allow("10.0.0.1", start.Add(21*time.Second)); ok {
+		t.Error("only one token should have refilled after 20s")
+	}
+}
+
+func TestRateLimiterWindowBoundary(t *testing.T) {
+	l := newRateLimiter(3, time.Minute)
+	start := time.Date(2026, 1, 1, 0, 0, 59, 0, time.UTC)
+
+	// A fixed window would admit a second full burst two seconds later, just past the minute
+	admitted := 0
+	for _, at := range []time.Time{start, start.Add(2 * time.Second)} {
+		for range 3 {
+			if ok, _ := l.allow("10.0.0.1", at); ok {
+				admitted++
+			}
+		}
+	}
+	if admitted != 3 {
+		t.Errorf("admitted %d requests across the boundary, want 3", admitted)
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	l := newRateLimiter(3, time.Minute)
+	start := time.Now()
+	l.allow("10.0.0.1", start)
+	l.cleanup(start.Add(30 * time.Second))
+	if len(l.clients) != 1 {
+		t.Error("a partially drained bucket should be kept")
+	}
+	l.cleanup(start.Add(time.Minute))
+	if len(l.clients) != 0 {
+		t.Error("a bucket idle for a full window should be dropped")
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	handler := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	var codes []int
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/payments", nil))
+		codes = append(codes, rec.Code)
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After = %q, want 30", rec.Header().Get("Retry-After"))
+		}
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("statuses = %v, want two admitted then 429", codes)
+	}
+}
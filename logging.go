@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const maxRequestIDLength = 128
+
+// setupLogging routes all logging, including the standard log package, through a JSON slog handler at the LOG_LEVEL verbosity.
+// Log lines must never carry the card number, CVV or expiry; log the card with maskCard.
+func setupLogging(level string) error {
+	var l slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		l = slog.LevelDebug
+	case "", "info":
+		l = slog.LevelInfo
+	case "warn":
+		l = slog.LevelWarn
+	case "error":
+		l = slog.LevelError
+	default:
+		return errors.New("LOG_LEVEL must be debug, info, warn or error")
+	}
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: l})))
+	return nil
+}
+
+// requestID returns the caller's X-Request-ID, or a new random one, and echoes it in the response for correlation
+func requestID(w http.ResponseWriter, r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > maxRequestIDLength {
+		b := make([]byte, 16)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+	w.Header().Set("X-Request-ID", id)
+	return id
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends slog and standard log output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	return &buf
+}
+
+func TestMaskCard(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"4242424242424242", "************4242"},
+		{"4242 4242 4242 4242", "************4242"},
+		{"378282246310005", "***********0005"},
+		{"4242", "****"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := maskCard(tt.in); got != tt.want {
+			t.Errorf("maskCard(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSetupLogging(t *testing.T) {
+	prev := slog.Default()
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	})
+	for _, level := range []string{"", "debug", "INFO", "warn", "error"} {
+		if err := setupLogging(level); err != nil {
+			t.Errorf("setupLogging(%q) = %v", level, err)
+		}
+	}
+	if err := setupLogging("verbose"); err == nil {
+		t.Error("an unknown level should be rejected")
+	}
+}
+
+func TestPaymentLogsNoCardData(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	logs := captureLogs(t)
+	const card, expiry, cvv = "4242424242424242", "12/39", "987"
+	body := `{"card_number":"` + card + `","expiry":"` + expiry + `","cvv":"` + cvv + `","amount":10.00}`
+
+	createPayment(t, body, nil)
+	declineRate = 1
+	t.Cleanup(func() { declineRate = 0 })
+	postPayment(t, body, nil)
+
+	out := logs.String()
+	if !strings.Contains(out, `"card":"************4242"`) {
+		t.Errorf("logs do not carry the masked card:\n%s", out)
+	}
+	for _, secret := range []string{card, expiry, `"` + cvv + `"`} {
+		if strings.Contains(out, secret) {
+			t.Errorf("logs contain %q:\n%s", secret, out)
+		}
+	}
+}
@@ -1,46 +1,159 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
-	"encoding/hex"
+	_ "embed"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"math/rand"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PaymentRequest defines the structure for incoming payment requests
 type PaymentRequest struct {
-	CardNumber string  `json:"card_number"`
-	Expiry     string  `json:"expiry"`
-	CVV        string  `json:"cvv"`
-	Amount     float64 `json:"amount"`
+	CardNumber         string      `json:"card_number"`
+	Expiry             string      `json:"expiry"`
+	CVV                string      `json:"cvv"`
+	Amount             json.Number `json:"amount"`
+	Currency           string      `json:"currency"`
+	DeviceFingerprint  string      `json:"device_fingerprint"`
+	AmountConfirmation string      `json:"amount_confirmation,omitempty"`
+	TaxID              string      `json:"tax_id,omitempty"`
+	TaxIDCountry       string      `json:"tax_id_country,omitempty"` // only valid together with tax_id
+	BillingCountry     string      `json:"billing_country,omitempty"`
+	Tags               []string    `json:"tags,omitempty"`
+	MCC                string      `json:"mcc,omitempty"`
 }
 
 // PaymentResponse defines the structure for payment responses
 type PaymentResponse struct {
 	Message       string `json:"message"`
 	TransactionID int    `json:"transaction_id"`
+	ReceiptURL    string `json:"receipt_url,omitempty"`
+	Sandbox       bool   `json:"sandbox,omitempty"`
+	Brand         string `json:"brand"`
+	Last4         string `json:"last4"`
+	Currency      string `json:"currency"`
+	DeclineReason string `json:"decline_reason,omitempty"`
+}
+
+// ErrorResponse defines the structure for JSON error responses
+type ErrorResponse struct {
+	Message        string   `json:"message"`
+	Code           string   `json:"code,omitempty"` // stable, machine-readable reason
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+}
+
+// ProblemResponse defines the RFC 7807 problem+json error structure
+type ProblemResponse struct {
+	Type           string   `json:"type"`
+	Title          string   `json:"title"`
+	Status         int      `json:"status"`
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+}
+
+// HealthResponse defines the structure for health check responses
+type HealthResponse struct {
+	Status string `json:"status"`
 }
 
 // Transaction defines the structure for stored transactions
 type Transaction struct {
-	ID        int
-	Token     string
-	Amount    float64
-	Status    string
-	CreatedAt time.Time
+	ID                int       `json:"id"`
+	Token             string    `json:"-"` // decrypts to the PAN; never leaves the server
+	Amount            Cents     `json:"amount"`
+	Status            string    `json:"status"`
+	CreatedAt         time.Time `json:"created_at"`
+	UserAgent         string    `json:"user_agent"`
+	DeviceFingerprint string    `json:"device_fingerprint"`
+	IdempotencyKey    string    `json:"-"`
+	APIKeyHash        string    `json:"-"` // scopes IdempotencyKey to the client that sent it
+	Last4             string    `json:"last4"`
+	Currency          string    `json:"currency"`
+	ParentID          *int      `json:"parent_id,omitempty"`
+	TaxID             string    `json:"-"` // PII; encrypted at rest and shown only on receipts
+	TaxIDCountry      string    `json:"-"`
+	BillingCountry    string    `json:"billing_country,omitempty"`
+	GeoMismatch       bool      `json:"geo_mismatch"` // card issued outside the billing country
+	Tags              []string  `json:"tags"`
+	MCC               string    `json:"mcc,omitempty"`
+	DeclineReason     string    `json:"-"` // set by sandbox mock declines; not stored
 }
 
+const (
+	// ISO/IEC 7812 allows card numbers of 13 to 19 digits
+	minCardNumberLength = 13
+	maxCardNumberLength = 19
+
+	maxDeviceFingerprintLength = 128
+	maxUserAgentLength         = 512
+	maxIdempotencyKeyLength    = 255
+	maxPaymentBodyBytes        = 16 << 10
+
+	defaultCurrency = "USD"
+
+	// Deadlocks and serialization failures are safe to retry a few times
+	maxDBRetries   = 3
+	dbRetryBackoff = 50 * time.Millisecond
+
+	defaultProcessorRetries = 3
+	processorRetryBackoff   = 100 * time.Millisecond
+
+	defaultStatementTimeout = 30 * time.Second
+	defaultDBTimeout        = 5 * time.Second
+	defaultMaxInFlightPerIP = 10
+	defaultRateLimit        = 60
+	defaultShutdownTimeout  = 15 * time.Second
+	healthCheckTimeout      = 2 * time.Second
+)
+
 var db *sql.DB
 
+//go:embed schema.sql
+var schemaSQL string
+
+// dbTimeout bounds each request's database work, overridable via DB_TIMEOUT
+var dbTimeout = defaultDBTimeout
+
+// declineRate is the fraction of payments the simulated processor declines, set via DECLINE_RATE
+var declineRate float64
+
+// processorErrorRate is the fraction of simulated processor calls that fail transiently, set via PROCESSOR_ERROR_RATE
+var processorErrorRate float64
+
+// processorRetries is the maximum number of processor attempts per payment, overridable via PROCESSOR_RETRIES
+var processorRetries = defaultProcessorRetries
+
+var errProcessorUnavailable = errors.New("payment processor unavailable")
+
+// sandboxMode relaxes card checks for client integration testing; it is never enabled in production
+var sandboxMode bool
+
+// supportedCurrencies is the ISO 4217 allow-list, overridable via SUPPORTED_CURRENCIES.
+// Amounts are stored in cents, so only currencies with two minor-unit digits belong here.
+var supportedCurrencies = []string{"USD", "EUR", "GBP", "CAD", "AUD", "NZD", "CHF", "SEK", "NOK", "DKK", "SGD", "HKD", "MXN"}
+
+// allowedContentTypes lists the request media types the API accepts, overridable via ALLOWED_CONTENT_TYPES
+var allowedContentTypes = []string{"application/json"}
+
 func main() {
 	// Load environment variables from .env file
 	err := godotenv.Load()
@@ -48,6 +161,61 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	// Emit structured JSON logs at the configured verbosity
+	err = setupLogging(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Fatal("Invalid LOG_LEVEL: ", err)
+	}
+
+	// Select the runtime environment; sandbox mode must be opted into explicitly
+	switch env := os.Getenv("ENVIRONMENT"); env {
+	case "", "production":
+	case "sandbox":
+		sandboxMode = true
+		log.Println("WARNING: running in sandbox mode, Luhn checks are disabled")
+	default:
+		log.Fatal("Invalid ENVIRONMENT: ", env)
+	}
+
+	// Configure the simulated processor's random decline rate
+	if rate := os.Getenv("DECLINE_RATE"); rate != "" {
+		declineRate, err = strconv.ParseFloat(rate, 64)
+		if err != nil || declineRate < 0 || declineRate > 1 {
+			log.Fatal("Invalid DECLINE_RATE: ", rate)
+		}
+	}
+
+	// Configure transient processor failures and how often they are retried
+	if rate := os.Getenv("PROCESSOR_ERROR_RATE"); rate != "" {
+		processorErrorRate, err = strconv.ParseFloat(rate, 64)
+		if err != nil || processorErrorRate < 0 || processorErrorRate > 1 {
+			log.Fatal("Invalid PROCESSOR_ERROR_RATE: ", rate)
+		}
+	}
+	if retries := os.Getenv("PROCESSOR_RETRIES"); retries != "" {
+		processorRetries, err = strconv.Atoi(retries)
+		if err != nil || processorRetries <= 0 {
+			log.Fatal("Invalid PROCESSOR_RETRIES: ", retries)
+		}
+	}
+
+	// Cap server-side query time on every pooled connection
+	statementTimeout := defaultStatementTimeout
+	if timeout := os.Getenv("DB_STATEMENT_TIMEOUT"); timeout != "" {
+		statementTimeout, err = time.ParseDuration(timeout)
+		if err != nil || statementTimeout <= 0 {
+			log.Fatal("Invalid DB_STATEMENT_TIMEOUT: ", timeout)
+		}
+	}
+
+	// Bound how long a request may wait on the database
+	if timeout := os.Getenv("DB_TIMEOUT"); timeout != "" {
+		dbTimeout, err = time.ParseDuration(timeout)
+		if err != nil || dbTimeout <= 0 {
+			log.Fatal("Invalid DB_TIMEOUT: ", timeout)
+		}
+	}
+
 	// Initialize database connection
 	connStr := "user=" + os.Getenv("DB_USER") +
 		" password=" + os.Getenv("DB_PASSWORD") +
@@ -55,7 +223,7 @@ func main() {
 		" host=" + os.Getenv("DB_HOST") +
 		" port=" + os.Getenv("DB_PORT") +
 		" sslmode=disable"
-	db, err = sql.Open("postgres", connStr)
+	db, err = sql.Open("postgres", databaseDSN(connStr, statementTimeout))
 	if err != nil {
 		log.Fatal("Failed to connect to database: ", err)
 	}
@@ -67,80 +235,561 @@ func main() {
 		log.Fatal("Database ping failed: ", err)
 	}
 
+	// Create or upgrade the schema so a fresh database works out of the box
+	err = migrate()
+	if err != nil {
+		log.Fatal("Failed to apply database schema: ", err)
+	}
+
+	// Periodically log processor latency and outcome summaries
+	if interval := os.Getenv("PROCESSOR_STATS_INTERVAL"); interval != "" {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			log.Fatal("Invalid PROCESSOR_STATS_INTERVAL: ", interval)
+		}
+		startProcessorStatsLogger(d)
+	}
+
+	// Load the accepted request content types
+	if types := os.Getenv("ALLOWED_CONTENT_TYPES"); types != "" {
+		allowedContentTypes = nil
+		for _, t := range strings.Split(types, ",") {
+			allowedContentTypes = append(allowedContentTypes, strings.ToLower(strings.TrimSpace(t)))
+		}
+	}
+
+	// Load the accepted currencies
+	if currencies := os.Getenv("SUPPORTED_CURRENCIES"); currencies != "" {
+		supportedCurrencies = nil
+		for _, c := range strings.Split(currencies, ",") {
+			supportedCurrencies = append(supportedCurrencies, strings.ToUpper(strings.TrimSpace(c)))
+		}
+	}
+
+	// Load the merchant category code applied to payments that do not send one
+	if mcc := os.Getenv("DEFAULT_MCC"); mcc != "" {
+		if !validateMCC(mcc) {
+			log.Fatal("Invalid DEFAULT_MCC: ", mcc)
+		}
+		defaultMCC = mcc
+	}
+
+	// Load the planned maintenance schedule, if any
+	maintenance, err = loadMaintenanceWindow()
+	if err != nil {
+		log.Fatal("Invalid maintenance window: ", err)
+	}
+
+	// Load per-country tax ID formats for B2B payments
+	taxIDPatterns, err = loadTaxIDPatterns(os.Getenv("TAX_ID_PATTERNS"))
+	if err != nil {
+		log.Fatal("Invalid TAX_ID_PATTERNS: ", err)
+	}
+
+	// Load BIN-to-brand corrections for ranges the built-in detection misclassifies
+	binBrandOverrides, err = loadBINBrandOverrides(os.Getenv("BIN_BRAND_OVERRIDES"))
+	if err != nil {
+		log.Fatal("Invalid BIN_BRAND_OVERRIDES: ", err)
+	}
+
+	// Load the card issuing countries used to flag billing country mismatches
+	binCountries, err = loadBINCountries(os.Getenv("BIN_COUNTRIES"))
+	if err != nil {
+		log.Fatal("Invalid BIN_COUNTRIES: ", err)
+	}
+
+	// Load the optional IP reputation check for anonymizer and Tor exit IPs
+	ipReputation, err = loadIPReputation()
+	if err != nil {
+		log.Fatal("Invalid IP reputation config: ", err)
+	}
+
+	// Load how long shareable receipt links remain valid
+	if ttl := os.Getenv("RECEIPT_TTL"); ttl != "" {
+		receiptTTL, err = time.ParseDuration(ttl)
+		if err != nil || receiptTTL <= 0 {
+			log.Fatal("Invalid RECEIPT_TTL: ", ttl)
+		}
+	}
+
+	// Start in read-only mode if requested; admins can toggle it at runtime
+	readOnly.Store(os.Getenv("READ_ONLY") == "true")
+
+	// Require server-to-server clients to sign payment bodies, if enabled
+	requireSignature = os.Getenv("REQUIRE_SIGNATURE") == "true"
+
+	// Notify a downstream service of payment outcomes, if configured
+	webhookURL = os.Getenv("WEBHOOK_URL")
+
+	// Load the API keys clients must present on the payment endpoints
+	loadAPIKeys(os.Getenv("API_KEYS"), os.Getenv("AMOUNT_CONFIRMATION_KEYS"))
+	if len(apiKeys) == 0 {
+		log.Fatal("API_KEYS environment variable not set")
+	}
+	amountConfirmationSecret = os.Getenv("AMOUNT_CONFIRMATION_SECRET")
+	if os.Getenv("AMOUNT_CONFIRMATION_KEYS") != "" && amountConfirmationSecret == "" {
+		log.Fatal("AMOUNT_CONFIRMATION_SECRET must be set when AMOUNT_CONFIRMATION_KEYS is")
+	}
+
+	// Card tokens and PII are encrypted under a key that, unlike SECRET_KEY, is never shared
+	if os.Getenv("TOKEN_ENCRYPTION_KEY") == "" {
+		log.Fatal("TOKEN_ENCRYPTION_KEY environment variable not set")
+	}
+	// SECRET_KEY signs webhooks and verifies X-Signature, so either feature needs it
+	if (requireSignature || webhookURL != "") && os.Getenv("SECRET_KEY") == "" {
+		log.Fatal("SECRET_KEY must be set when REQUIRE_SIGNATURE or WEBHOOK_URL is")
+	}
+
+	// Load the server-only key for signed cursors and links
+	internalSigningKey = os.Getenv("INTERNAL_SIGNING_KEY")
+	if internalSigningKey == "" {
+		log.Fatal("INTERNAL_SIGNING_KEY environment variable not set")
+	}
+
+	// Health probe for the load balancer
+	http.HandleFunc("/healthz", handleHealthz)
+
+	// Prometheus metrics, exempt from auth and rate limiting so scrapers always get through
+	http.HandleFunc("/metrics", handleMetrics)
+
 	// Serve static files (HTML, CSS, JS)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
+	// Cap concurrent requests per client IP to mitigate connection exhaustion
+	maxInFlight := defaultMaxInFlightPerIP
+	if limit := os.Getenv("MAX_CONCURRENT_PER_IP"); limit != "" {
+		maxInFlight, err = strconv.Atoi(limit)
+		if err != nil || maxInFlight <= 0 {
+			log.Fatal("Invalid MAX_CONCURRENT_PER_IP: ", limit)
+		}
+	}
+	inFlight := newInFlightLimiter(maxInFlight)
+
+	// Limit payment attempts per client IP per minute to slow card-testing abuse
+	rateLimit := defaultRateLimit
+	if limit := os.Getenv("RATE_LIMIT"); limit != "" {
+		rateLimit, err = strconv.Atoi(limit)
+		if err != nil || rateLimit <= 0 {
+			log.Fatal("Invalid RATE_LIMIT: ", limit)
+		}
+	}
+	trustProxy = os.Getenv("TRUST_PROXY") == "true"
+	paymentLimiter = newRateLimiter(rateLimit, time.Minute)
+	paymentLimiter.startCleanup()
+
 	// API endpoint for payment processing
-	http.HandleFunc("/api/payments", handlePayment)
+	http.Handle("/api/payments", inFlight.middleware(paymentLimiter.middleware(requireAPIKey(rejectWritesWhenReadOnly(http.HandlerFunc(handlePayment))))))
+
+	// API endpoint for submitting many payments at once, e.g. from billing jobs
+	http.Handle("/api/payments/batch", inFlight.middleware(paymentLimiter.middleware(requireAPIKey(rejectWritesWhenReadOnly(http.HandlerFunc(handleBatchPayment))))))
+
+	// API endpoints for transaction listing and lookup
+	http.Handle("/api/transactions", inFlight.middleware(requireAPIKey(http.HandlerFunc(handleListTransactions))))
+	http.Handle("/api/transactions/", inFlight.middleware(requireAPIKey(http.HandlerFunc(handleGetTransaction))))
+
+	// API endpoint for refunds
+	http.Handle("/api/refunds", inFlight.middleware(requireAPIKey(rejectWritesWhenReadOnly(http.HandlerFunc(handleRefund)))))
+
+	// Admin endpoint for toggling read-only mode during incidents
+	http.HandleFunc("/api/admin/read-only", handleReadOnly)
+
+	// Public endpoint for signed, time-limited receipt links
+	http.Handle("/api/receipts/", inFlight.middleware(http.HandlerFunc(handleReceipt)))
+
+	// Allow in-flight payments to finish when shutting down
+	shutdownTimeout := defaultShutdownTimeout
+	if timeout := os.Getenv("SHUTDOWN_TIMEOUT"); timeout != "" {
+		shutdownTimeout, err = time.ParseDuration(timeout)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatal("Invalid SHUTDOWN_TIMEOUT: ", timeout)
+		}
+	}
 
 	// Start server
-	log.Println("Server starting on :8080")
-	err = http.ListenAndServe(":8080", nil)
+	server := &http.Server{Addr: ":8080"}
+	go func() {
+		log.Println("Server starting on :8080")
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM, then stop accepting connections and drain active requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Printf("Shutting down, waiting up to %v for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Printf("Shutdown did not complete cleanly: %v", err)
 	}
+
+	// Give queued webhook deliveries the rest of the shutdown budget
+	webhooksDone := make(chan struct{})
+	go func() {
+		pendingWebhooks.Wait()
+		close(webhooksDone)
+	}()
+	select {
+	case <-webhooksDone:
+	case <-shutdownCtx.Done():
+		log.Println("Shutdown timed out with webhook deliveries pending")
+	}
+	log.Println("Server stopped")
 }
 
 // handlePayment processes incoming payment requests
 func handlePayment(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+	reqID := requestID(w, r)
+
+	// Reject charges up front during planned processor maintenance
+	if until, active := maintenance.activeUntil(time.Now()); active {
+		rejectForMaintenance(w, until)
+		return
+	}
+
+	if ipReputation.blocks(r.Context(), clientIP(r)) {
+		http.Error(w, "Payment blocked: ip_blocked", http.StatusForbidden)
+		return
+	}
+
+	if !validateContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, ok := readPaymentBody(w, r, maxPaymentBodyBytes)
+	if !ok {
 		return
 	}
 
 	var req PaymentRequest
-	err := json.NewDecoder(r.Body).Decode(&req)
+	err := json.Unmarshal(body, &req)
 	if err != nil {
+		metrics.recordRejection("invalid_payload")
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
 	// Input validation
-	if !validateCardNumber(req.CardNumber) {
-		http.Error(w, "Invalid card number", http.StatusBadRequest)
+	brand := DetectBrand(req.CardNumber)
+	amount, currency, rejection := validatePayment(&req, brand, apiKeyFromContext(r.Context()))
+	if rejection != nil {
+		metrics.recordRejection(rejection.reason)
+		http.Error(w, rejection.message, http.StatusBadRequest)
 		return
 	}
-	if !validateExpiry(req.Expiry) {
-		http.Error(w, "Invalid expiry date", http.StatusBadRequest)
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		metrics.recordRejection("invalid_idempotency_key")
+		http.Error(w, "Invalid idempotency key", http.StatusBadRequest)
 		return
 	}
-	if !validateCVV(req.CVV) {
-		http.Error(w, "Invalid CVV", http.StatusBadRequest)
-		return
+
+	// In sandbox mode, X-Mock-Response forces the processor outcome so SDKs can exercise every branch
+	var mockScenario string
+	if sandboxMode {
+		mockScenario = r.Header.Get("X-Mock-Response")
+		if mockScenario != "" && !validateMockScenario(mockScenario) {
+			http.Error(w, "Unsupported mock scenario", http.StatusBadRequest)
+			return
+		}
 	}
-	if req.Amount <= 0 {
-		http.Error(w, "Invalid amount", http.StatusBadRequest)
+	switch mockScenario {
+	case "error:processor_timeout":
+		slog.Info("Using mock processor response", "scenario", mockScenario, "request_id", reqID)
+		http.Error(w, "Payment processor timed out", http.StatusGatewayTimeout)
+		return
+	case "requires_action":
+		slog.Info("Using mock processor response", "scenario", mockScenario, "request_id", reqID)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPaymentRequired)
+		json.NewEncoder(w).Encode(ErrorResponse{Message: "Payment requires customer authentication", Code: "requires_action"})
 		return
 	}
 
+	// Capture device details for fraud investigations
+	userAgent := sanitizeUserAgent(r.UserAgent())
+
 	// Tokenize card details
 	token := tokenizeCard(req.CardNumber)
 
+	txn := &Transaction{
+		Token:             token,
+		Amount:            amount,
+		UserAgent:         userAgent,
+		DeviceFingerprint: req.DeviceFingerprint,
+		IdempotencyKey:    idempotencyKey,
+		APIKeyHash:        apiKeyFromContext(r.Context()).hexHash(),
+		Last4:             cardLast4(req.CardNumber),
+		Currency:          currency,
+		TaxID:             req.TaxID,
+		TaxIDCountry:      req.TaxIDCountry,
+		BillingCountry:    req.BillingCountry,
+		GeoMismatch:       geoMismatch(req.CardNumber, req.BillingCountry),
+		Tags:              req.Tags,
+		MCC:               req.MCC,
+	}
+
+	// Reserve the idempotency key before charging so concurrent retries cannot both reach the processor,
+	// and replay the original outcome when the key is already taken
+	if idempotencyKey != "" {
+		prior, err := reserveIdempotencyKey(r.Context(), txn)
+		if isDBTimeout(err) {
+			log.Printf("Database timeout reserving idempotency key: %v", err)
+			http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to reserve idempotency key: %v", err)
+			http.Error(w, "Failed to process payment", http.StatusInternalServerError)
+			return
+		}
+		if prior != nil {
+			// Tokens are randomized per call, so compare the card behind the prior token instead
+			priorCard, err := detokenizeCard(prior.Token)
+			if err != nil || priorCard != normalizeCardNumber(req.CardNumber) || prior.Amount != amount || prior.Currency != currency {
+				http.Error(w, "Idempotency key already used for a different payment", http.StatusConflict)
+				return
+			}
+			if prior.Status == "pending" {
+				http.Error(w, "A payment with this idempotency key is still in progress", http.StatusConflict)
+				return
+			}
+			slog.Info("Replaying payment for idempotency key", "transaction_id", prior.ID, "request_id", reqID)
+			writePaymentResponse(w, prior, prior.Status == "success", brand)
+			return
+		}
+	}
+
 	// Process payment and store transaction
-	transactionID, success := processAndStorePayment(token, req.Amount, req.Expiry, req.CVV)
+	transactionID, success, err := processAndStorePayment(r.Context(), txn, req.Expiry, req.CVV, mockScenario)
+	if isDBTimeout(err) {
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, errProcessorUnavailable) {
+		slog.Error("Payment processor unavailable", "card", maskCard(req.CardNumber), "error", err.Error(), "request_id", reqID)
+		http.Error(w, "Payment processor unavailable", http.StatusBadGateway)
+		return
+	}
+	// Only returned before the card is charged; a charged payment is always stored
+	if errors.Is(err, context.Canceled) {
+		slog.Info("Payment abandoned: client disconnected", "card", maskCard(req.CardNumber), "request_id", reqID)
+		return
+	}
+
+	// Log transaction; the card number only ever appears masked
+	slog.Info("Payment processed",
+		"card", maskCard(req.CardNumber),
+		"amount", amount.String(),
+		"currency", currency,
+		"success", success,
+		"geo_mismatch", txn.GeoMismatch,
+		"transaction_id", transactionID,
+		"request_id", reqID,
+	)
+
+	if err == nil {
+		metrics.recordPayment(success)
+		sendPaymentWebhook(txn, success)
+	}
+	writePaymentResponse(w, txn, success, brand)
+}
+
+// readPaymentBody reads the request body, up to maxBytes, once so the signature covers exactly the bytes that are
+// decoded. It writes an error response and returns false when the body is too large, unreadable or wrongly signed.
+func readPaymentBody(w http.ResponseWriter, r *http.Request, maxBytes int64) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return nil, false
+	}
+	if requireSignature && !validateSignature(body, r.Header.Get("X-Signature")) {
+		http.Error(w, "Invalid signature", http.StatusUnauthorized)
+		return nil, false
+	}
+	return body, true
+}
 
-	// Log transaction
-	log.Printf("Payment processed: token=%s, amount=%.2f, success=%v, transaction_id=%d, time=%v",
-		token, req.Amount, success, transactionID, time.Now())
+// paymentRejection describes why a payment request failed validation
+type paymentRejection struct {
+	reason  string // validation rejection metric label
+	message string // client-facing error
+}
 
+// validatePayment checks a decoded payment request, returning its amount and normalized currency.
+// key is the authenticating API key, which may require an amount confirmation.
+func validatePayment(req *PaymentRequest, brand string, key *apiKey) (Cents, string, *paymentRejection) {
+	if !validateCardNumber(req.CardNumber) {
+		return 0, "", &paymentRejection{"invalid_card_number", "Invalid card number"}
+	}
+	if !validateExpiry(req.Expiry) {
+		return 0, "", &paymentRejection{"invalid_expiry", "Invalid expiry date"}
+	}
+	if !validateCVV(req.CVV, brand) {
+		return 0, "", &paymentRejection{"invalid_cvv", "Invalid CVV"}
+	}
+	amount, err := parseCents(req.Amount.String())
+	if errors.Is(err, errAmountPrecision) {
+		return 0, "", &paymentRejection{"amount_precision", "Amount must have at most two decimal places"}
+	}
+	if err != nil || amount <= 0 {
+		return 0, "", &paymentRejection{"invalid_amount", "Invalid amount"}
+	}
+	currency, ok := normalizeCurrency(req.Currency)
+	if !ok {
+		return 0, "", &paymentRejection{"unsupported_currency", "Unsupported currency"}
+	}
+	if key != nil && key.requireAmountConfirmation && !validateAmountConfirmation(amount, currency, req.AmountConfirmation) {
+		return 0, "", &paymentRejection{"amount_tampered", "Payment rejected: amount_tampered"}
+	}
+	if !validateDeviceFingerprint(req.DeviceFingerprint) {
+		return 0, "", &paymentRejection{"invalid_device_fingerprint", "Invalid device fingerprint"}
+	}
+	req.BillingCountry, ok = normalizeCountry(req.BillingCountry)
+	if !ok {
+		return 0, "", &paymentRejection{"invalid_billing_country", "Invalid billing country"}
+	}
+	req.Tags, ok = normalizeTags(req.Tags)
+	if !ok {
+		return 0, "", &paymentRejection{"invalid_tags", "Invalid tags"}
+	}
+	req.MCC, ok = normalizeMCC(req.MCC)
+	if !ok {
+		return 0, "", &paymentRejection{"invalid_mcc", "Invalid merchant category code"}
+	}
+	req.TaxID, req.TaxIDCountry = strings.TrimSpace(req.TaxID), strings.ToUpper(strings.TrimSpace(req.TaxIDCountry))
+	if req.TaxID == "" && req.TaxIDCountry != "" {
+		return 0, "", &paymentRejection{"invalid_tax_id", "Tax ID country requires a tax ID"}
+	}
+	if !validateTaxID(req.TaxID, req.TaxIDCountry) {
+		return 0, "", &paymentRejection{"invalid_tax_id", "Invalid tax ID"}
+	}
+	return amount, currency, nil
+}
+
+// writePaymentResponse writes the JSON outcome of a payment
+func writePaymentResponse(w http.ResponseWriter, txn *Transaction, success bool, brand string) {
 	w.Header().Set("Content-Type", "application/json")
-	resp := PaymentResponse{TransactionID: transactionID}
 	if success {
-		resp.Message = "Payment successful"
 		w.WriteHeader(http.StatusOK)
 	} else {
-		resp.Message = "Payment failed"
 		w.WriteHeader(http.StatusInternalServerError)
 	}
-	json.NewEncoder(w).Encode(resp)
+	json.NewEncoder(w).Encode(newPaymentResponse(txn, success, brand))
+}
+
+// newPaymentResponse builds the response for a processed payment, with a receipt link when it succeeded
+func newPaymentResponse(txn *Transaction, success bool, brand string) PaymentResponse {
+	resp := PaymentResponse{TransactionID: txn.ID, Sandbox: sandboxMode, Brand: brand, Last4: txn.Last4, Currency: txn.Currency}
+	if success {
+		resp.Message = "Payment successful"
+		resp.ReceiptURL = "/api/receipts/" + newReceiptToken(txn.ID, time.Now().Add(receiptTTL))
+	} else {
+		resp.Message = "Payment failed"
+		resp.DeclineReason = txn.DeclineReason
+	}
+	return resp
+}
+
+// databaseDSN adds the session statement_timeout to a key=value connection string. The driver sends
+// unrecognised keys such as statement_timeout as session parameters when each connection starts.
+func databaseDSN(connStr string, statementTimeout time.Duration) string {
+	return connStr + " statement_timeout=" + strconv.FormatInt(statementTimeout.Milliseconds(), 10)
+}
+
+// migrate applies schema.sql, which is idempotent, and logs whether the transactions table was created
+func migrate() error {
+	var exists bool
+	err := db.QueryRow("SELECT to_regclass('transactions') IS NOT NULL").Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(schemaSQL); err != nil {
+		return err
+	}
+	if exists {
+		log.Println("Database schema up to date, transactions table already existed")
+	} else {
+		log.Println("Database schema applied, created transactions table")
+	}
+	return nil
+}
+
+// handleHealthz reports whether the database is reachable
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+	w.Header().Set("Content-Type", "application/json")
+	if err := db.PingContext(ctx); err != nil {
+		log.Printf("Health check failed: %v", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(HealthResponse{Status: "unavailable"})
+		return
+	}
+	json.NewEncoder(w).Encode(HealthResponse{Status: "ok"})
 }
 
-// validateCardNumber checks if the card number is valid using the Luhn algorithm
+// methodNotAllowed writes a JSON 405 listing the allowed methods, as problem+json when the client asks for it
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	if strings.Contains(r.Header.Get("Accept"), "application/problem+json") {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(ProblemResponse{
+			Type:           "about:blank",
+			Title:          "Method Not Allowed",
+			Status:         http.StatusMethodNotAllowed,
+			AllowedMethods: allowed,
+		})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusMethodNotAllowed)
+	json.NewEncoder(w).Encode(ErrorResponse{Message: "Method not allowed", AllowedMethods: allowed})
+}
+
+// validateContentType checks the media type against the allowlist, ignoring parameters such as charset
+func validateContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(allowedContentTypes, mediaType)
+}
+
+// validateCardNumber checks if the card number is valid using the Luhn algorithm.
+// In sandbox mode only the length and digit checks apply.
 func validateCardNumber(cardNumber string) bool {
-	cardNumber = regexp.MustCompile(`\s+`).ReplaceAllString(cardNumber, "")
-	if len(cardNumber) != 16 {
+	cardNumber = normalizeCardNumber(cardNumber)
+	if len(cardNumber) < minCardNumberLength || len(cardNumber) > maxCardNumberLength {
+		return false
+	}
+	if matched, _ := regexp.MatchString(`^\d+$`, cardNumber); !matched {
 		return false
 	}
+	if sandboxMode {
+		return true
+	}
 	sum := 0
 	isEven := false
 	for i := len(cardNumber) - 1; i >= 0; i-- {
@@ -157,87 +806,367 @@ func validateCardNumber(cardNumber string) bool {
 	return sum%10 == 0
 }
 
+// normalizeCardNumber strips whitespace from a card number
+func normalizeCardNumber(cardNumber string) string {
+	return regexp.MustCompile(`\s+`).ReplaceAllString(cardNumber, "")
+}
+
+// maskCard masks all but the last four digits of a card number, e.g. ************1234
+func maskCard(cardNumber string) string {
+	cardNumber = normalizeCardNumber(cardNumber)
+	if len(cardNumber) <= 4 {
+		return strings.Repeat("*", len(cardNumber))
+	}
+	return strings.Repeat("*", len(cardNumber)-4) + cardNumber[len(cardNumber)-4:]
+}
+
+// cardLast4 returns the last four digits of a card number
+func cardLast4(cardNumber string) string {
+	cardNumber = normalizeCardNumber(cardNumber)
+	if len(cardNumber) < 4 {
+		return ""
+	}
+	return cardNumber[len(cardNumber)-4:]
+}
+
+// DetectBrand identifies the card network from the IIN prefix and length, returning "unknown" if unrecognized.
+// Configured BIN overrides take precedence over the built-in ranges.
+func DetectBrand(cardNumber string) string {
+	cardNumber = normalizeCardNumber(cardNumber)
+	if brand, ok := binBrandOverride(cardNumber); ok {
+		return brand
+	}
+	length := len(cardNumber)
+	switch {
+	case prefixInRange(cardNumber, 1, 4, 4) && (length == 13 || length == 16 || length == 19):
+		return "visa"
+	case (prefixInRange(cardNumber, 2, 51, 55) || prefixInRange(cardNumber, 4, 2221, 2720)) && length == 16:
+		return "mastercard"
+	case (prefixInRange(cardNumber, 2, 34, 34) || prefixInRange(cardNumber, 2, 37, 37)) && length == 15:
+		return "amex"
+	case (prefixInRange(cardNumber, 4, 6011, 6011) || prefixInRange(cardNumber, 3, 644, 649) ||
+		prefixInRange(cardNumber, 2, 65, 65) || prefixInRange(cardNumber, 6, 622126, 622925)) &&
+		length >= 16 && length <= 19:
+		return "discover"
+	default:
+		return "unknown"
+	}
+}
+
+// prefixInRange checks if the first digits of the card number fall within [low, high]
+func prefixInRange(cardNumber string, digits, low, high int) bool {
+	if len(cardNumber) < digits {
+		return false
+	}
+	prefix, err := strconv.Atoi(cardNumber[:digits])
+	if err != nil {
+		return false
+	}
+	return prefix >= low && prefix <= high
+}
+
 // validateExpiry checks if the expiry date is valid and not in the past
 func validateExpiry(expiry string) bool {
+	return validateExpiryAt(expiry, time.Now())
+}
+
+// validateExpiryAt checks expiry against now. A card is valid through the last day of its
+// expiry month, and the two-digit year is read in the century nearest to now so that
+// "01/00" means 2100 when checked in 2099.
+func validateExpiryAt(expiry string, now time.Time) bool {
 	matched, _ := regexp.MatchString(`^\d{2}/\d{2}$`, expiry)
 	if !matched {
 		return false
 	}
-	parts := regexp.MustCompile(`/`).Split(expiry, -1)
-	month, _ := strconv.Atoi(parts[0])
-	year, _ := strconv.Atoi(parts[1])
-	currentYear := time.Now().Year() % 100
-	currentMonth := int(time.Now().Month())
-	return month >= 1 && month <= 12 && year >= currentYear && (year > currentYear || month >= currentMonth)
+	monthPart, yearPart, _ := strings.Cut(expiry, "/")
+	month, err := strconv.Atoi(monthPart)
+	if err != nil || month < 1 || month > 12 {
+		return false
+	}
+	year, err := strconv.Atoi(yearPart)
+	if err != nil {
+		return false
+	}
+	year += now.Year() / 100 * 100
+	if year < now.Year()-50 {
+		year += 100
+	} else if year > now.Year()+50 {
+		year -= 100
+	}
+	// Expiry is the first instant of the following month
+	expiresAt := time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+	return now.Before(expiresAt)
 }
 
-// validateCVV checks if the CVV is a 3-digit number
-func validateCVV(cvv string) bool {
-	matched, _ := regexp.MatchString(`^\d{3}$`, cvv)
+// validateCVV checks if the CVV is a 4-digit number for Amex and a 3-digit number otherwise
+func validateCVV(cvv, brand string) bool {
+	pattern := `^\d{3}$`
+	if brand == "amex" {
+		pattern = `^\d{4}$`
+	}
+	matched, _ := regexp.MatchString(pattern, cvv)
 	return matched
 }
 
-// tokenizeCard generates a secure token from the card number
-func tokenizeCard(cardNumber string) string {
-	secretKey := os.Getenv("SECRET_KEY")
-	if secretKey == "" {
-		log.Fatal("SECRET_KEY environment variable not set")
+// normalizeCurrency uppercases an ISO 4217 code and checks it against the allow-list, defaulting to USD
+func normalizeCurrency(currency string) (string, bool) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		return defaultCurrency, true
 	}
-	hash := sha256.Sum256([]byte(cardNumber + secretKey))
-	return hex.EncodeToString(hash[:])
+	return currency, slices.Contains(supportedCurrencies, currency)
 }
 
-// processAndStorePayment processes the payment and stores it in the database
-func processAndStorePayment(token string, amount float64, expiry, cvv string) (int, bool) {
+// sanitizeUserAgent replaces invalid UTF-8, which Postgres would reject on insert, and truncates
+// to maxUserAgentLength bytes without splitting a multibyte character
+func sanitizeUserAgent(userAgent string) string {
+	userAgent = strings.ToValidUTF8(userAgent, "\uFFFD")
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	cut := maxUserAgentLength
+	for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+		cut--
+	}
+	return userAgent[:cut]
+}
+
+// validateDeviceFingerprint checks that the optional device fingerprint is of acceptable length
+func validateDeviceFingerprint(fingerprint string) bool {
+	return len(fingerprint) <= maxDeviceFingerprintLength
+}
+
+// processAndStorePayment processes the payment and stores it in the database, completing the pending
+// row when reserveIdempotencyKey has already stored txn. A non-empty mockScenario replaces the processor
+// call with a forced outcome.
+func processAndStorePayment(ctx context.Context, txn *Transaction, expiry, cvv, mockScenario string) (int, bool, error) {
 	// Simulate payment processor interaction
-	success := processPayment(token, amount, expiry, cvv)
+	var success bool
+	if mockScenario != "" {
+		success, txn.DeclineReason = mockProcessorResponse(mockScenario)
+	} else {
+		start := time.Now()
+		var err error
+		success, err = processPayment(ctx, txn.Token, txn.Amount, txn.MCC, expiry, cvv)
+		if err != nil {
+			// Nothing was charged, so free the idempotency key for a retry
+			releaseIdempotencyKey(ctx, txn)
+			return 0, false, err
+		}
+		procStats.record(time.Since(start), success)
+	}
 
 	// Store transaction
-	status := "failed"
+	txn.Status = "failed"
 	if success {
-		status = "success"
+		txn.Status = "success"
+	}
+	// The card has been charged, so record it even if the client has disconnected or the request timed out
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dbTimeout)
+	defer cancel()
+	var err error
+	if txn.ID != 0 {
+		_, err = db.ExecContext(ctx, "UPDATE transactions SET status = $1 WHERE id = $2", txn.Status, txn.ID)
+	} else {
+		txn.CreatedAt = time.Now()
+		err = insertTransaction(ctx, txn)
+	}
+	if isDBTimeout(err) {
+		log.Printf("Database timeout storing transaction: %v", err)
+		return 0, false, err
 	}
-	var transactionID int
-	err := db.QueryRow(
-		"INSERT INTO transactions (token, amount, status, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
-		token, amount, status, time.Now(),
-	).Scan(&transactionID)
 	if err != nil {
 		log.Printf("Failed to store transaction: %v", err)
-		return 0, false
+		return 0, false, err
 	}
 
-	return transactionID, success
+	return txn.ID, success, nil
 }
 
-// processPayment simulates interaction with a payment processor
-func processPayment(token string, amount float64, expiry, cvv string) bool {
-	if token == "" {
-		log.Printf("Payment failed: empty token")
+// insertTransaction stores txn and sets its ID. When txn's idempotency key is already taken it stores
+// nothing and returns sql.ErrNoRows.
+func insertTransaction(ctx context.Context, txn *Transaction) error {
+	var encryptedTaxID string
+	if txn.TaxID != "" {
+		encryptedTaxID = encryptPII(txn.TaxID)
+	}
+	return withDBRetry(func() error {
+		return db.QueryRowContext(ctx,
+			"INSERT INTO transactions (token, amount_cents, status, created_at, user_agent, device_fingerprint, idempotency_key, api_key_hash, last4, currency, tax_id, tax_id_country, billing_country, geo_mismatch, tags, mcc) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) ON CONFLICT (api_key_hash, idempotency_key) DO NOTHING RETURNING id",
+			txn.Token, txn.Amount, txn.Status, txn.CreatedAt, txn.UserAgent, txn.DeviceFingerprint,
+			sql.NullString{String: txn.IdempotencyKey, Valid: txn.IdempotencyKey != ""}, txn.APIKeyHash, txn.Last4, txn.Currency,
+			encryptedTaxID, txn.TaxIDCountry, txn.BillingCountry, txn.GeoMismatch, pq.Array(txn.Tags), txn.MCC,
+		).Scan(&txn.ID)
+	})
+}
+
+// reserveIdempotencyKey atomically stores txn as a pending transaction under its idempotency key, before
+// the card is charged. It returns nil once the key is reserved, or the transaction already holding the key.
+func reserveIdempotencyKey(ctx context.Context, txn *Transaction) (*Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	txn.Status = "pending"
+	txn.CreatedAt = time.Now()
+	// A conflicting reservation can be released between the insert and the lookup, so try again then
+	for attempt := 1; attempt <= maxDBRetries; attempt++ {
+		err := insertTransaction(ctx, txn)
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		prior, err := findTransactionByIdempotencyKey(ctx, txn.APIKeyHash, txn.IdempotencyKey)
+		if prior != nil || err != nil {
+			return prior, err
+		}
+	}
+	return nil, errors.New("idempotency key reservation kept being released")
+}
+
+// releaseIdempotencyKey deletes the pending row reserveIdempotencyKey stored for txn, if any. It runs even
+// when ctx is cancelled, since a stale reservation would block the client's retry.
+func releaseIdempotencyKey(ctx context.Context, txn *Transaction) {
+	if txn.ID == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dbTimeout)
+	defer cancel()
+	_, err := db.ExecContext(ctx, "DELETE FROM transactions WHERE id = $1 AND status = 'pending'", txn.ID)
+	if err != nil {
+		log.Printf("Failed to release idempotency key for transaction %d: %v", txn.ID, err)
+		return
+	}
+	txn.ID = 0
+}
+
+// findTransactionByIdempotencyKey returns the transaction stored for key under the given API key, or nil if there is none
+func findTransactionByIdempotencyKey(ctx context.Context, apiKeyHash, key string) (*Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var txn Transaction
+	err := db.QueryRowContext(ctx,
+		"SELECT id, token, amount_cents, status, last4, currency FROM transactions WHERE api_key_hash = $1 AND idempotency_key = $2",
+		apiKeyHash, key,
+	).Scan(&txn.ID, &txn.Token, &txn.Amount, &txn.Status, &txn.Last4, &txn.Currency)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	txn.IdempotencyKey = key
+	txn.APIKeyHash = apiKeyHash
+	return &txn, nil
+}
+
+// isDBTimeout checks if a database error was caused by a context deadline or a cancelled statement
+func isDBTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014"
+}
+
+// withDBRetry runs fn, retrying with exponential backoff when the database reports a deadlock or serialization failure
+func withDBRetry(fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !isRetryableDBError(err) || attempt == maxDBRetries {
+			return err
+		}
+		log.Printf("Retrying database operation (attempt %d): %v", attempt, err)
+		time.Sleep(dbRetryBackoff << (attempt - 1))
+	}
+}
+
+// isRetryableDBError checks if the error is a Postgres deadlock (40P01) or serialization failure (40001)
+func isRetryableDBError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
 		return false
 	}
+	return pqErr.Code == "40P01" || pqErr.Code == "40001"
+}
+
+// validateMockScenario checks if an X-Mock-Response value is a supported scenario:
+// approved, declined, declined:<reason>, requires_action or error:processor_timeout
+func validateMockScenario(scenario string) bool {
+	matched, _ := regexp.MatchString(`^(approved|declined(:[a-z_]+)?|requires_action|error:processor_timeout)$`, scenario)
+	return matched
+}
+
+// mockProcessorResponse returns the forced outcome for a sandbox mock scenario, and the <reason> of a
+// declined:<reason> scenario
+func mockProcessorResponse(scenario string) (bool, string) {
+	slog.Info("Using mock processor response", "scenario", scenario)
+	_, reason, _ := strings.Cut(scenario, ":")
+	return scenario == "approved", reason
+}
+
+// processPayment charges the card, retrying transient processor errors with exponential backoff and jitter.
+// Hard declines return false with a nil error and are never retried.
+func processPayment(ctx context.Context, token string, amount Cents, mcc, expiry, cvv string) (bool, error) {
+	start := time.Now()
+	defer func() { metrics.observeLatency(time.Since(start)) }()
+
+	if token == "" {
+		slog.Warn("Payment failed: empty token")
+		return false, nil
+	}
 	if amount <= 0 {
-		log.Printf("Payment failed: invalid amount %.2f", amount)
-		return false
+		slog.Warn("Payment failed: invalid amount", "amount", amount.String())
+		return false, nil
 	}
 	if expiry == "" {
-		log.Printf("Payment failed: empty expiry")
-		return false
+		slog.Warn("Payment failed: empty expiry")
+		return false, nil
 	}
 	if cvv == "" {
-		log.Printf("Payment failed: empty CVV")
-		return false
+		slog.Warn("Payment failed: empty CVV")
+		return false, nil
 	}
-	if len(cvv) != 3 {
-		log.Printf("Payment failed: invalid CVV length")
-		return false
+	if len(cvv) != 3 && len(cvv) != 4 {
+		slog.Warn("Payment failed: invalid CVV length")
+		return false, nil
 	}
-	// Simulate payment processor interaction 
-	// Random failure simulation (20% chance)
-	/*	if time.Now().UnixNano()%10 < 2 {
-		log.Printf("Payment failed: processor declined (token=%s, amount=%.2f)", token, amount)
-		return false
-	}*/
-	log.Printf("Payment approved: token=%s, amount=%.2f", token, amount)
-	return true
+
+	backoff := processorRetryBackoff
+	for attempt := 1; ; attempt++ {
+		// Do not charge a client that has already gone away
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		approved, err := chargeProcessor(token, amount, mcc)
+		if err == nil {
+			return approved, nil
+		}
+		if !errors.Is(err, errProcessorUnavailable) || attempt == processorRetries {
+			return false, fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		// Full jitter spreads retries from concurrent payments
+		wait := time.Duration(rand.Int63n(int64(backoff)) + 1)
+		slog.Warn("Retrying processor call", "attempt", attempt, "wait", wait.String(), "error", err.Error())
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff *= 2
+	}
+}
+
+// chargeProcessor makes a single processor call; tests swap it to script processor behaviour
+var chargeProcessor = simulateCharge
+
+// simulateCharge simulates a single processor call, which may fail transiently (PROCESSOR_ERROR_RATE)
+// or decline (DECLINE_RATE)
+func simulateCharge(token string, amount Cents, mcc string) (bool, error) {
+	if rand.Float64() < processorErrorRate {
+		return false, errProcessorUnavailable
+	}
+	if rand.Float64() < declineRate {
+		slog.Info("Payment failed: processor declined", "amount", amount.String(), "mcc", mcc)
+		return false, nil
+	}
+	slog.Info("Payment approved", "amount", amount.String(), "mcc", mcc, "sandbox", sandboxMode)
+	return true, nil
 }
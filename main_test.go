@@ -0,0 +1,180 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// openTestDB points db at TEST_DATABASE_URL, applies the schema and empties the transactions table.
+// Tests that need Postgres are skipped when it is unset.
+func openTestDB(t *testing.T) {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	var err error
+	db, err = sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		db = nil
+	})
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("TRUNCATE transactions RESTART IDENTITY"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setTestSecrets configures the keys the handlers need
+func setTestSecrets(t *testing.T) {
+	t.Helper()
+	t.Setenv("SECRET_KEY", "test-secret")
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "test-encryption-key")
+	internalSigningKey = "test-signing-key"
+	t.Cleanup(func() { internalSigningKey = "" })
+}
+
+// futureExpiry returns an MM/YY expiry two years from now
+func futureExpiry() string {
+	return time.Now().AddDate(2, 0, 0).Format("01/06")
+}
+
+// postPayment sends body to handlePayment with the given headers
+func postPayment(t *testing.T, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	handlePayment(rec, req)
+	return rec
+}
+
+// testPaymentBody returns a valid payment request for amount in the given card's brand
+func testPaymentBody(cardNumber, amount string) string {
+	cvv := "123"
+	if DetectBrand(cardNumber) == "amex" {
+		cvv = "1234"
+	}
+	return `{"card_number":"` + cardNumber + `","expiry":"` + futureExpiry() + `","cvv":"` + cvv + `","amount":` + amount + `}`
+}
+
+// createPayment posts a payment that must succeed and returns its response
+func createPayment(t *testing.T, body string, headers map[string]string) PaymentResponse {
+	t.Helper()
+	rec := postPayment(t, body, headers)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("payment status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp PaymentResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestSanitizeUserAgent(t *testing.T) {
+	long := strings.Repeat("a", maxUserAgentLength-1) + "é" // the two-byte é straddles the limit
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "curl/8.0", "curl/8.0"},
+		{"invalid utf-8", "agent\xff\xfe", "agent�"},
+		{"truncated on rune boundary", long, strings.Repeat("a", maxUserAgentLength-1)},
+		{"truncated ascii", strings.Repeat("b", maxUserAgentLength+10), strings.Repeat("b", maxUserAgentLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sanitizeUserAgent(tt.in)
+			if got != tt.want {
+				t.Errorf("sanitizeUserAgent() = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxUserAgentLength {
+				t.Errorf("sanitizeUserAgent() = %q is not valid, bounded UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestValidateDeviceFingerprint(t *testing.T) {
+	if !validateDeviceFingerprint("") || !validateDeviceFingerprint(strings.Repeat("f", maxDeviceFingerprintLength)) {
+		t.Error("fingerprints up to the maximum length should be accepted")
+	}
+	if validateDeviceFingerprint(strings.Repeat("f", maxDeviceFingerprintLength+1)) {
+		t.Error("an overlong fingerprint should be rejected")
+	}
+}
+
+func TestPaymentStoresDeviceDetails(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"device_fingerprint":"device-abc"}`
+	rec := postPayment(t, body, map[string]string{"User-Agent": "test-agent/1.0 \xff"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+
+	var userAgent, fingerprint string
+	err := db.QueryRow("SELECT user_agent, device_fingerprint FROM transactions WHERE id = 1").Scan(&userAgent, &fingerprint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userAgent != "test-agent/1.0 �" || fingerprint != "device-abc" {
+		t.Errorf("stored user_agent=%q device_fingerprint=%q", userAgent, fingerprint)
+	}
+}
+
+func TestWithDBRetry(t *testing.T) {
+	t.Run("deadlock then success", func(t *testing.T) {
+		calls := 0
+		err := withDBRetry(func() error {
+			calls++
+			if calls == 1 {
+				return &pq.Error{Code: "40P01"}
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("err = %v after %d calls, want success on the second call", err, calls)
+		}
+	})
+	t.Run("gives up after max retries", func(t *testing.T) {
+		calls := 0
+		err := withDBRetry(func() error {
+			calls++
+			return &pq.Error{Code: "40001"}
+		})
+		if !isRetryableDBError(err) || calls != maxDBRetries {
+			t.Errorf("err = %v after %d calls, want serialization failure after %d", err, calls, maxDBRetries)
+		}
+	})
+	t.Run("other errors are not retried", func(t *testing.T) {
+		calls := 0
+		err := withDBRetry(func() error {
+			calls++
+			return &pq.Error{Code: "23505"}
+		})
+		if err == nil || calls != 1 {
+			t.Errorf("err = %v after %d calls, want one failed call", err, calls)
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// maintenanceWindow describes a planned processor outage during which charges are rejected
+type maintenanceWindow struct {
+	start  time.Time
+	end    time.Time
+	repeat time.Duration // zero for a one-off window
+}
+
+// maintenance is nil unless a window is configured
+var maintenance *maintenanceWindow
+
+// loadMaintenanceWindow reads MAINTENANCE_START, MAINTENANCE_END and the optional MAINTENANCE_REPEAT
+func loadMaintenanceWindow() (*maintenanceWindow, error) {
+	startEnv, endEnv := os.Getenv("MAINTENANCE_START"), os.Getenv("MAINTENANCE_END")
+	if startEnv == "" && endEnv == "" {
+		return nil, nil
+	}
+	start, err := time.Parse(time.RFC3339, startEnv)
+	if err != nil {
+		return nil, errors.New("MAINTENANCE_START must be an RFC 3339 timestamp")
+	}
+	end, err := time.Parse(time.RFC3339, endEnv)
+	if err != nil {
+		return nil, errors.New("MAINTENANCE_END must be an RFC 3339 timestamp")
+	}
+	if !end.After(start) {
+		return nil, errors.New("MAINTENANCE_END must be after MAINTENANCE_START")
+	}
+	m := &maintenanceWindow{start: start, end: end}
+	if repeat := os.Getenv("MAINTENANCE_REPEAT"); repeat != "" {
+		m.repeat, err = time.ParseDuration(repeat)
+		if err != nil || m.repeat < end.Sub(start) {
+			return nil, errors.New("MAINTENANCE_REPEAT must be a duration no shorter than the window")
+		}
+	}
+	return m, nil
+}
+
+// activeUntil returns the end of the window containing now, if any
+func (m *maintenanceWindow) activeUntil(now time.Time) (time.Time, bool) {
+	if m == nil {
+		return time.Time{}, false
+	}
+	start, end := m.start, m.end
+	if m.repeat > 0 && now.After(start) {
+		offset := now.Sub(start) / m.repeat * m.repeat
+		start, end = start.Add(offset), end.Add(offset)
+	}
+	if now.Before(start) || !now.Before(end) {
+		return time.Time{}, false
+	}
+	return end, true
+}
+
+// rejectForMaintenance writes a 503 with the "maintenance" code, telling the client to retry when the window ends
+func rejectForMaintenance(w http.ResponseWriter, until time.Time) {
+	w.Header().Set("Retry-After", until.UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(ErrorResponse{Message: "Payments are unavailable due to scheduled maintenance", Code: "maintenance"})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowActiveUntil(t *testing.T) {
+	start := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	once := &maintenanceWindow{start: start, end: end}
+	weekly := &maintenanceWindow{start: start, end: end, repeat: 7 * 24 * time.Hour}
+
+	tests := []struct {
+		name      string
+		window    *maintenanceWindow
+		now       time.Time
+		wantUntil time.Time
+		wantOK    bool
+	}{
+		{"none configured", nil, start, time.Time{}, false},
+		{"before", once, start.Add(-time.Minute), time.Time{}, false},
+		{"at start", once, start, end, true},
+		{"inside", once, start.Add(30 * time.Minute), end, true},
+		{"at end", once, end, time.Time{}, false},
+		{"one-off does not repeat", once, start.Add(7 * 24 * time.Hour), time.Time{}, false},
+		{"next weekly window", weekly, start.Add(7*24*time.Hour + time.Minute), end.Add(7 * 24 * time.Hour), true},
+		{"between weekly windows", weekly, start.Add(3 * 24 * time.Hour), time.Time{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, ok := tt.window.activeUntil(tt.now)
+			if ok != tt.wantOK || !until.Equal(tt.wantUntil) {
+				t.Errorf("activeUntil() = %v, %v; want %v, %v", until, ok, tt.wantUntil, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLoadMaintenanceWindow(t *testing.T) {
+	t.Setenv("MAINTENANCE_START", "2026-03-01T02:00:00Z")
+	t.Setenv("MAINTENANCE_END", "2026-03-01T01:00:00Z")
+	if _, err := loadMaintenanceWindow(); err == nil {
+		t.Error("an end before the start should be rejected")
+	}
+	t.Setenv("MAINTENANCE_END", "2026-03-01T03:00:00Z")
+	t.Setenv("MAINTENANCE_REPEAT", "30m")
+	if _, err := loadMaintenanceWindow(); err == nil {
+		t.Error("a repeat shorter than the window should be rejected")
+	}
+}
+
+func TestPaymentRejectedDuringMaintenance(t *testing.T) {
+	maintenance = &maintenanceWindow{start: time.Now().Add(-time.Minute), end: time.Now().Add(time.Hour)}
+	t.Cleanup(func() { maintenance = nil })
+
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"single": postPayment(t, `{}`, nil),
+		"batch":  postBatch(`[{}]`),
+	} {
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("%s: status = %d, want 503", name, rec.Code)
+		}
+		if rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After header", name)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if resp.Code != "maintenance" {
+			t.Errorf("%s: code = %q, want maintenance", name, resp.Code)
+		}
+	}
+}
+
+func TestPaymentAcceptedOutsideMaintenance(t *testing.T) {
+	maintenance = &maintenanceWindow{start: time.Now().Add(time.Hour), end: time.Now().Add(2 * time.Hour)}
+	t.Cleanup(func() { maintenance = nil })
+
+	// An empty body gets past the maintenance check and fails validation instead
+	rec := postPayment(t, `{}`, nil)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNormalizeMCC(t *testing.T) {
+	defaultMCC = "5999"
+	t.Cleanup(func() { defaultMCC = "" })
+
+	tests := []struct {
+		name   string
+		in     string
+		want   string
+		wantOK bool
+	}{
+		{"known", "5411", "5411", true},
+		{"trimmed", " 5812 ", "5812", true},
+		{"airline brand code", "3001", "3001", true},
+		{"hotel brand code", "3750", "3750", true},
+		{"absent uses merchant default", "", "5999", true},
+		{"unassigned", "0001", "0001", false},
+		{"gap between brand ranges", "3300", "3300", false},
+		{"three digits", "541", "541", false},
+		{"letters", "54a1", "54a1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeMCC(tt.in)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("normalizeMCC(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPaymentRejectsUnknownMCC(t *testing.T) {
+	setTestSecrets(t)
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"mcc":"0001"}`
+	if rec := postPayment(t, body, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestPaymentStoresMCC(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	defaultMCC = "5999"
+	t.Cleanup(func() { defaultMCC = "" })
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"provided", `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"mcc":"5411"}`, "5411"},
+		{"merchant default", testPaymentBody("4242424242424242", "10.00"), "5999"},
+	}
+	for _, tt := range tests {
+		resp := createPayment(t, tt.body, nil)
+		txn, err := getTransaction(t.Context(), resp.TransactionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txn.MCC != tt.want {
+			t.Errorf("%s: stored mcc = %q, want %q", tt.name, txn.MCC, tt.want)
+		}
+	}
+}
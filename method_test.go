@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		handlePayment(rec, httptest.NewRequest(http.MethodGet, "/api/payments", nil))
+		if rec.Code != http.StatusMethodNotAllowed {
+			t.Fatalf("status = %d, want 405", rec.Code)
+		}
+		if got := rec.Header().Get("Allow"); got != http.MethodPost {
+			t.Errorf("Allow = %q, want POST", got)
+		}
+		if got := rec.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(resp.AllowedMethods, []string{http.MethodPost}) {
+			t.Errorf("allowed_methods = %v", resp.AllowedMethods)
+		}
+	})
+	t.Run("problem+json", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/api/payments", nil)
+		req.Header.Set("Accept", "application/problem+json")
+		rec := httptest.NewRecorder()
+		handlePayment(rec, req)
+		if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+			t.Errorf("Content-Type = %q", got)
+		}
+		var resp ProblemResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != http.StatusMethodNotAllowed || resp.Title != "Method Not Allowed" || !slices.Equal(resp.AllowedMethods, []string{http.MethodPost}) {
+			t.Errorf("problem = %+v", resp)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// paymentMetrics holds the counters and histogram served on /metrics
+type paymentMetrics struct {
+	registry   *prometheus.Registry
+	payments   *prometheus.CounterVec // by status
+	rejections *prometheus.CounterVec // by validation reason
+	latency    prometheus.Histogram
+	overrides  *prometheus.CounterVec // by configured BIN prefix and brand
+	handler    http.Handler
+}
+
+var metrics = newPaymentMetrics()
+
+// newPaymentMetrics registers the payment metrics, plus the Go runtime and process collectors, on a fresh registry
+func newPaymentMetrics() *paymentMetrics {
+	m := &paymentMetrics{
+		registry: prometheus.NewRegistry(),
+		payments: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "payments_total",
+			Help: "Payment attempts by outcome.",
+		}, []string{"status"}),
+		rejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "payment_validation_rejections_total",
+			Help: "Payment requests rejected by validation, by reason.",
+		}, []string{"reason"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "payment_processing_seconds",
+			Help:    "Payment processor call latency.",
+			Buckets: []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+		}),
+		overrides: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "bin_brand_overrides_total",
+			Help: "Card brands taken from BIN_BRAND_OVERRIDES, by override prefix.",
+		}, []string{"prefix", "brand"}),
+	}
+	// Export both outcomes from the start so rate() queries have a series before the first payment
+	m.payments.WithLabelValues("success")
+	m.payments.WithLabelValues("failed")
+	m.registry.MustRegister(
+		m.payments,
+		m.rejections,
+		m.latency,
+		m.overrides,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	m.handler = promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return m
+}
+
+// recordPayment counts a completed payment attempt as success or failed
+func (m *paymentMetrics) recordPayment(success bool) {
+	status := "failed"
+	if success {
+		status = "success"
+	}
+	m.payments.WithLabelValues(status).Inc()
+}
+
+// recordRejection counts a payment request rejected by input validation
+func (m *paymentMetrics) recordRejection(reason string) {
+	m.rejections.WithLabelValues(reason).Inc()
+}
+
+// observeLatency adds one processor call duration to the histogram
+func (m *paymentMetrics) observeLatency(d time.Duration) {
+	m.latency.Observe(d.Seconds())
+}
+
+// recordBINOverride counts a brand detected through the override for prefix
+func (m *paymentMetrics) recordBINOverride(prefix, brand string) {
+	m.overrides.WithLabelValues(prefix, brand).Inc()
+}
+
+// handleMetrics serves the metrics in the Prometheus exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+	metrics.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrapeMetrics fetches /metrics through the real route handler
+func scrapeMetrics(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(handleMetrics))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestMetricsScrape(t *testing.T) {
+	metrics = newPaymentMetrics()
+	t.Cleanup(func() { metrics = newPaymentMetrics() })
+
+	metrics.recordPayment(true)
+	metrics.recordPayment(true)
+	metrics.recordPayment(false)
+	metrics.observeLatency(30 * time.Millisecond)
+	setTestSecrets(t)
+	postPayment(t, `{"card_number":"1234","expiry":"`+futureExpiry()+`","cvv":"123","amount":10.00}`, nil)
+
+	out := scrapeMetrics(t)
+	for _, want := range []string{
+		`payments_total{status="success"} 2`,
+		`payments_total{status="failed"} 1`,
+		`payment_processing_seconds_bucket{le="0.05"} 1`,
+		`payment_processing_seconds_count 1`,
+		`payment_validation_rejections_total{reason="invalid_card_number"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("scrape does not contain %q", want)
+		}
+	}
+}
+
+func TestMetricsMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
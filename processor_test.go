@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateMockScenario(t *testing.T) {
+	tests := []struct {
+		scenario string
+		want     bool
+	}{
+		{"approved", true},
+		{"declined", true},
+		{"declined:insufficient_funds", true},
+		{"error:processor_timeout", true},
+		{"requires_action", true},
+		{"declined:", false},
+		{"declined:Bad", false},
+		{"error:other", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validateMockScenario(tt.scenario); got != tt.want {
+			t.Errorf("validateMockScenario(%q) = %v, want %v", tt.scenario, got, tt.want)
+		}
+	}
+	if ok, _ := mockProcessorResponse("approved"); !ok {
+		t.Error("the approved scenario should succeed")
+	}
+	if ok, reason := mockProcessorResponse("declined:insufficient_funds"); ok || reason != "insufficient_funds" {
+		t.Errorf("mockProcessorResponse(declined:insufficient_funds) = %v, %q; want false, insufficient_funds", ok, reason)
+	}
+	if ok, reason := mockProcessorResponse("declined"); ok || reason != "" {
+		t.Errorf("mockProcessorResponse(declined) = %v, %q; want false, no reason", ok, reason)
+	}
+}
+
+func TestMockResponseInSandbox(t *testing.T) {
+	setTestSecrets(t)
+	sandboxMode = true
+	t.Cleanup(func() { sandboxMode = false })
+	body := testPaymentBody("4242424242424242", "10.00")
+
+	rec := postPayment(t, body, map[string]string{"X-Mock-Response": "error:processor_timeout"})
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Errorf("processor timeout: status = %d, want 504", rec.Code)
+	}
+	rec = postPayment(t, body, map[string]string{"X-Mock-Response": "requires_action"})
+	var resp ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusPaymentRequired || resp.Code != "requires_action" {
+		t.Errorf("requires action: status = %d, code = %q; want 402, requires_action", rec.Code, resp.Code)
+	}
+	rec = postPayment(t, body, map[string]string{"X-Mock-Response": "bogus"})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported scenario: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestMockResponseDeclinesInSandbox(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	sandboxMode = true
+	t.Cleanup(func() { sandboxMode = false })
+
+	rec := postPayment(t, testPaymentBody("4242424242424242", "10.00"), map[string]string{"X-Mock-Response": "declined:insufficient_funds"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want the declined response", rec.Code)
+	}
+	var resp PaymentResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.DeclineReason != "insufficient_funds" {
+		t.Errorf("decline_reason = %q, want insufficient_funds", resp.DeclineReason)
+	}
+}
+
+func TestMockResponseIgnoredOutsideSandbox(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+
+	rec := postPayment(t, testPaymentBody("4242424242424242", "10.00"), map[string]string{"X-Mock-Response": "declined"})
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want the real processor outcome", rec.Code)
+	}
+}
+
+func TestDeclineRate(t *testing.T) {
+	t.Cleanup(func() { declineRate = 0 })
+	for _, tt := range []struct {
+		rate float64
+		want bool
+	}{{0, true}, {1, false}} {
+		declineRate = tt.rate
+		for range 20 {
+			approved, err := chargeProcessor("tok", 1000, "5999")
+			if err != nil || approved != tt.want {
+				t.Fatalf("rate %v: chargeProcessor() = %v, %v; want %v", tt.rate, approved, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestProcessPaymentStopsWhenContextCancelled(t *testing.T) {
+	calls := scriptProcessor(t, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := processPayment(ctx, "tok", 1000, "", "12/30", "123")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+	if *calls != 0 {
+		t.Errorf("processor called %d times after the client went away", *calls)
+	}
+}
+
+func TestPaymentAbandonedWhenClientDisconnects(t *testing.T) {
+	setTestSecrets(t)
+	processorErrorRate = 1
+	t.Cleanup(func() { processorErrorRate = 0 })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(testPaymentBody("4242424242424242", "10.00")))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handlePayment(rec, req)
+	if rec.Body.Len() != 0 {
+		t.Errorf("wrote %q to a disconnected client", rec.Body)
+	}
+}
+
+func TestChargedPaymentStoredWhenClientDisconnects(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	// The client goes away while the processor is approving the charge
+	chargeProcessor = func(string, Cents, string) (bool, error) {
+		cancel()
+		return true, nil
+	}
+	t.Cleanup(func() { chargeProcessor = simulateCharge })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/payments", strings.NewReader(testPaymentBody("4242424242424242", "10.00")))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", "order-5")
+	handlePayment(httptest.NewRecorder(), req)
+
+	var status string
+	if err := db.QueryRow("SELECT status FROM transactions WHERE idempotency_key = 'order-5'").Scan(&status); err != nil {
+		t.Fatalf("charged payment was not stored: %v", err)
+	}
+	if status != "success" {
+		t.Errorf("status = %q, want success", status)
+	}
+}
+
+func TestStoreTimesOutWithDBTimeout(t *testing.T) {
+	openTestDB(t)
+	dbTimeout = time.Nanosecond
+	t.Cleanup(func() { dbTimeout = defaultDBTimeout })
+
+	_, err := getTransaction(context.Background(), 1)
+	if !isDBTimeout(err) {
+		t.Errorf("err = %v, want a database timeout", err)
+	}
+}
+
+// scriptProcessor replaces chargeProcessor with one returning results in order, and counts calls
+func scriptProcessor(t *testing.T, results ...error) *int {
+	t.Helper()
+	calls := 0
+	chargeProcessor = func(string, Cents, string) (bool, error) {
+		err := results[min(calls, len(results)-1)]
+		calls++
+		return err == nil, err
+	}
+	t.Cleanup(func() { chargeProcessor = simulateCharge })
+	return &calls
+}
+
+var errDeclined = errors.New("declined")
+
+func TestProcessPaymentRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []error
+		wantOK    bool
+		wantErr   error
+		wantCalls int
+	}{
+		{"transient then success", []error{errProcessorUnavailable, errProcessorUnavailable, nil}, true, nil, 3},
+		{"gives up after max attempts", []error{errProcessorUnavailable}, false, errProcessorUnavailable, defaultProcessorRetries},
+		{"other errors are not retried", []error{errDeclined}, false, errDeclined, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := scriptProcessor(t, tt.results...)
+			ok, err := processPayment(context.Background(), "tok", 1000, "", "12/30", "123")
+			if ok != tt.wantOK || !errors.Is(err, tt.wantErr) || *calls != tt.wantCalls {
+				t.Errorf("processPayment() = %v, %v after %d calls; want %v, %v after %d",
+					ok, err, *calls, tt.wantOK, tt.wantErr, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProcessPaymentHardDeclineNotRetried(t *testing.T) {
+	calls := 0
+	chargeProcessor = func(string, Cents, string) (bool, error) {
+		calls++
+		return false, nil
+	}
+	t.Cleanup(func() { chargeProcessor = simulateCharge })
+
+	ok, err := processPayment(context.Background(), "tok", 1000, "", "12/30", "123")
+	if ok || err != nil || calls != 1 {
+		t.Errorf("processPayment() = %v, %v after %d calls; want a single declined call", ok, err, calls)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReceiptResponse defines the structure for receipts served from signed links
+type ReceiptResponse struct {
+	TransactionID int       `json:"transaction_id"`
+	Amount        Cents     `json:"amount"`
+	Currency      string    `json:"currency"`
+	Status        string    `json:"status"`
+	CreatedAt     time.Time `json:"created_at"`
+	TaxID         string    `json:"tax_id,omitempty"`
+	TaxIDCountry  string    `json:"tax_id_country,omitempty"`
+}
+
+const defaultReceiptTTL = 24 * time.Hour
+
+// receiptTTL is how long a receipt link stays valid, overridable via RECEIPT_TTL
+var receiptTTL = defaultReceiptTTL
+
+var (
+	errReceiptInvalid = errors.New("invalid receipt token")
+	errReceiptExpired = errors.New("receipt token expired")
+)
+
+// newReceiptToken creates an unforgeable token granting access to a transaction's receipt until expires
+func newReceiptToken(transactionID int, expires time.Time) string {
+	payload := strconv.Itoa(transactionID) + "." + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signReceipt(payload))
+}
+
+// parseReceiptToken verifies a receipt token and returns the transaction ID it grants access to
+func parseReceiptToken(token string, now time.Time) (int, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, errReceiptInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return 0, errReceiptInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, signReceipt(string(payload))) {
+		return 0, errReceiptInvalid
+	}
+
+	idPart, expiresPart, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return 0, errReceiptInvalid
+	}
+	transactionID, err := strconv.Atoi(idPart)
+	if err != nil {
+		return 0, errReceiptInvalid
+	}
+	expires, err := strconv.ParseInt(expiresPart, 10, 64)
+	if err != nil {
+		return 0, errReceiptInvalid
+	}
+	if now.Unix() >= expires {
+		return 0, errReceiptExpired
+	}
+	return transactionID, nil
+}
+
+// signReceipt computes the HMAC of a receipt payload, domain-separated from other uses of INTERNAL_SIGNING_KEY.
+// Receipts show the decrypted tax ID, so links must not be mintable with SECRET_KEY, which clients hold.
+func signReceipt(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(internalSigningKey))
+	mac.Write([]byte("receipt:" + payload))
+	return mac.Sum(nil)
+}
+
+// handleReceipt serves the receipt for a signed, unexpired receipt link without requiring auth
+func handleReceipt(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	transactionID, err := parseReceiptToken(strings.TrimPrefix(r.URL.Path, "/api/receipts/"), time.Now())
+	if err != nil {
+		http.Error(w, "Invalid or expired receipt link", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbTimeout)
+	defer cancel()
+	var receipt ReceiptResponse
+	var encryptedTaxID string
+	err = db.QueryRowContext(ctx,
+		"SELECT id, amount_cents, currency, status, created_at, tax_id, tax_id_country FROM transactions WHERE id = $1",
+		transactionID,
+	).Scan(&receipt.TransactionID, &receipt.Amount, &receipt.Currency, &receipt.Status, &receipt.CreatedAt, &encryptedTaxID, &receipt.TaxIDCountry)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Receipt not found", http.StatusNotFound)
+		return
+	}
+	if isDBTimeout(err) {
+		log.Printf("Database timeout loading receipt for transaction %d: %v", transactionID, err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load receipt for transaction %d: %v", transactionID, err)
+		http.Error(w, "Failed to load receipt", http.StatusInternalServerError)
+		return
+	}
+	if encryptedTaxID != "" {
+		receipt.TaxID, err = decryptPII(encryptedTaxID)
+		if err != nil {
+			log.Printf("Failed to decrypt tax ID for transaction %d: %v", transactionID, err)
+			http.Error(w, "Failed to load receipt", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(receipt)
+}
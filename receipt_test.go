@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseReceiptToken(t *testing.T) {
+	setTestSecrets(t)
+	now := time.Now()
+	valid := newReceiptToken(42, now.Add(time.Hour))
+	payload, sig, _ := strings.Cut(valid, ".")
+	forged := newReceiptToken(43, now.Add(time.Hour))
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantID  int
+		wantErr error
+	}{
+		{"valid", valid, 42, nil},
+		{"expired", newReceiptToken(42, now.Add(-time.Second)), 0, errReceiptExpired},
+		{"payload swapped", forgedPayload + "." + sig, 0, errReceiptInvalid},
+		{"signature altered", payload + "." + strings.Repeat("A", len(sig)), 0, errReceiptInvalid},
+		{"no signature", payload, 0, errReceiptInvalid},
+		{"not base64", "!!!.???", 0, errReceiptInvalid},
+		{"empty", "", 0, errReceiptInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := parseReceiptToken(tt.token, now)
+			if id != tt.wantID || err != tt.wantErr {
+				t.Errorf("parseReceiptToken() = %d, %v; want %d, %v", id, err, tt.wantID, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReceiptTokenDependsOnKey(t *testing.T) {
+	setTestSecrets(t)
+	token := newReceiptToken(42, time.Now().Add(time.Hour))
+
+	// SECRET_KEY is shared with clients, so it must play no part in receipt links
+	t.Setenv("SECRET_KEY", "another-secret")
+	if id, err := parseReceiptToken(token, time.Now()); err != nil || id != 42 {
+		t.Errorf("parseReceiptToken() = %d, %v after changing SECRET_KEY", id, err)
+	}
+	internalSigningKey = "another-signing-key"
+	if _, err := parseReceiptToken(token, time.Now()); err != errReceiptInvalid {
+		t.Errorf("err = %v, want a token signed with another key to be rejected", err)
+	}
+}
+
+func TestHandleReceiptRejectsBadToken(t *testing.T) {
+	setTestSecrets(t)
+	rec := httptest.NewRecorder()
+	handleReceipt(rec, httptest.NewRequest(http.MethodGet, "/api/receipts/bogus", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// RefundRequest defines the structure for incoming refund requests
+type RefundRequest struct {
+	TransactionID int         `json:"transaction_id"`
+	Amount        json.Number `json:"amount"`
+}
+
+// RefundResponse defines the structure for refund responses
+type RefundResponse struct {
+	Message         string `json:"message"`
+	RefundID        int    `json:"refund_id"`
+	TransactionID   int    `json:"transaction_id"`
+	Amount          Cents  `json:"amount"`
+	RemainingAmount Cents  `json:"remaining_amount"`
+	Currency        string `json:"currency"`
+}
+
+var (
+	errTransactionNotFound  = errors.New("transaction not found")
+	errNotRefundable        = errors.New("transaction was not successful")
+	errFullyRefunded        = errors.New("transaction already fully refunded")
+	errRefundExceedsBalance = errors.New("refund exceeds remaining refundable amount")
+)
+
+// handleRefund refunds all or part of a successful payment
+func handleRefund(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, r, http.MethodPost)
+		return
+	}
+
+	if !validateContentType(r.Header.Get("Content-Type")) {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req RefundRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.TransactionID <= 0 {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+	amount, err := parseCents(req.Amount.String())
+	if errors.Is(err, errAmountPrecision) {
+		http.Error(w, "Amount must have at most two decimal places", http.StatusBadRequest)
+		return
+	}
+	if err != nil || amount <= 0 {
+		http.Error(w, "Invalid amount", http.StatusBadRequest)
+		return
+	}
+
+	refund, remaining, err := storeRefund(r.Context(), req.TransactionID, amount)
+	switch {
+	case isDBTimeout(err):
+		log.Printf("Database timeout storing refund for transaction %d: %v", req.TransactionID, err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	case errors.Is(err, errTransactionNotFound):
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	case errors.Is(err, errNotRefundable):
+		http.Error(w, "Only successful payments can be refunded", http.StatusConflict)
+		return
+	case errors.Is(err, errFullyRefunded):
+		http.Error(w, "Transaction already fully refunded", http.StatusConflict)
+		return
+	case errors.Is(err, errRefundExceedsBalance):
+		http.Error(w, "Refund amount exceeds remaining refundable amount", http.StatusBadRequest)
+		return
+	case err != nil:
+		log.Printf("Failed to store refund for transaction %d: %v", req.TransactionID, err)
+		http.Error(w, "Refund failed", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("Refund processed",
+		"last4", refund.Last4,
+		"amount", refund.Amount.String(),
+		"currency", refund.Currency,
+		"refund_id", refund.ID,
+		"transaction_id", req.TransactionID,
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RefundResponse{
+		Message:         "Refund successful",
+		RefundID:        refund.ID,
+		TransactionID:   req.TransactionID,
+		Amount:          refund.Amount,
+		RemainingAmount: remaining,
+		Currency:        refund.Currency,
+	})
+}
+
+// storeRefund records a refund linked to the original transaction, returning it with the amount still refundable.
+// The original row is locked so concurrent refunds cannot exceed its amount.
+func storeRefund(ctx context.Context, transactionID int, amount Cents) (*Transaction, Cents, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var refund *Transaction
+	var remaining Cents
+	err := withDBRetry(func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		var parent Transaction
+		err = tx.QueryRowContext(ctx,
+			"SELECT id, token, amount_cents, status, last4, currency FROM transactions WHERE id = $1 FOR UPDATE",
+			transactionID,
+		).Scan(&parent.ID, &parent.Token, &parent.Amount, &parent.Status, &parent.Last4, &parent.Currency)
+		if errors.Is(err, sql.ErrNoRows) {
+			return errTransactionNotFound
+		}
+		if err != nil {
+			return err
+		}
+		if parent.Status != "success" {
+			return errNotRefundable
+		}
+
+		var refunded Cents
+		err = tx.QueryRowContext(ctx,
+			"SELECT COALESCE(SUM(amount_cents), 0) FROM transactions WHERE parent_id = $1 AND status = 'refunded'",
+			transactionID,
+		).Scan(&refunded)
+		if err != nil {
+			return err
+		}
+		remaining = parent.Amount - refunded
+		if remaining <= 0 {
+			return errFullyRefunded
+		}
+		if amount > remaining {
+			return errRefundExceedsBalance
+		}
+
+		refund = &Transaction{
+			Token:     parent.Token,
+			Amount:    amount,
+			Status:    "refunded",
+			CreatedAt: time.Now(),
+			Last4:     parent.Last4,
+			Currency:  parent.Currency,
+			ParentID:  &parent.ID,
+		}
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO transactions (token, amount_cents, status, created_at, last4, parent_id, currency) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+			refund.Token, refund.Amount, refund.Status, refund.CreatedAt, refund.Last4, parent.ID, refund.Currency,
+		).Scan(&refund.ID)
+		if err != nil {
+			return err
+		}
+		remaining -= amount
+		return tx.Commit()
+	})
+	return refund, remaining, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postRefund sends body to handleRefund
+func postRefund(body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/refunds", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handleRefund(rec, req)
+	return rec
+}
+
+func TestRefundRejectsInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"malformed", `{`},
+		{"missing transaction", `{"amount":1.00}`},
+		{"zero amount", `{"transaction_id":1,"amount":0}`},
+		{"sub-cent amount", `{"transaction_id":1,"amount":1.005}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := postRefund(tt.body); rec.Code != http.StatusBadRequest {
+				t.Errorf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+}
+
+func TestRefunds(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	paid := createPayment(t, testPaymentBody("4242424242424242", "10.00"), nil)
+
+	declineRate = 1
+	t.Cleanup(func() { declineRate = 0 })
+	if rec := postPayment(t, testPaymentBody("4242424242424242", "10.00"), nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("declined payment: status = %d", rec.Code)
+	}
+	declineRate = 0
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantRemaining Cents
+	}{
+		{"partial", `{"transaction_id":1,"amount":4.00}`, http.StatusOK, 600},
+		{"over-refund", `{"transaction_id":1,"amount":6.01}`, http.StatusBadRequest, 0},
+		{"remainder", `{"transaction_id":1,"amount":6.00}`, http.StatusOK, 0},
+		{"already fully refunded", `{"transaction_id":1,"amount":0.01}`, http.StatusConflict, 0},
+		{"failed parent", `{"transaction_id":2,"amount":1.00}`, http.StatusConflict, 0},
+		{"unknown transaction", `{"transaction_id":999,"amount":1.00}`, http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		rec := postRefund(tt.body)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status = %d, want %d, body %s", tt.name, rec.Code, tt.wantStatus, rec.Body)
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+		var resp RefundResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.TransactionID != paid.TransactionID || resp.RemainingAmount != tt.wantRemaining {
+			t.Errorf("%s: refund = %+v, want remaining %v", tt.name, resp, tt.wantRemaining)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPReputation reports whether a client IP is flagged, e.g. as a Tor exit node or anonymizing proxy
+type IPReputation interface {
+	Flagged(ctx context.Context, ip string) (bool, error)
+}
+
+const defaultIPReputationCacheTTL = 10 * time.Minute
+
+// ipReputationPolicy applies an IPReputation source to charges; nil when the check is disabled
+type ipReputationPolicy struct {
+	source IPReputation
+	block  bool // reject flagged IPs; otherwise only log them
+}
+
+// ipReputation is nil unless IP_BLOCKLIST_FILE is configured
+var ipReputation *ipReputationPolicy
+
+// loadIPReputation reads IP_BLOCKLIST_FILE, the optional IP_REPUTATION_ACTION ("block" or "flag")
+// and IP_REPUTATION_CACHE_TTL
+func loadIPReputation() (*ipReputationPolicy, error) {
+	path := os.Getenv("IP_BLOCKLIST_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	list, err := loadIPBlocklist(path)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &ipReputationPolicy{block: true}
+	switch action := os.Getenv("IP_REPUTATION_ACTION"); action {
+	case "", "block":
+	case "flag":
+		policy.block = false
+	default:
+		return nil, errors.New("IP_REPUTATION_ACTION must be \"block\" or \"flag\"")
+	}
+
+	ttl := defaultIPReputationCacheTTL
+	if v := os.Getenv("IP_REPUTATION_CACHE_TTL"); v != "" {
+		ttl, err = time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return nil, errors.New("IP_REPUTATION_CACHE_TTL must be a positive duration")
+		}
+	}
+	cache := newCachedIPReputation(list, ttl)
+	cache.startCleanup()
+	policy.source = cache
+	return policy, nil
+}
+
+// blocks reports whether a charge from ip should be rejected. Lookup failures are logged and let the charge through.
+func (p *ipReputationPolicy) blocks(ctx context.Context, ip string) bool {
+	if p == nil {
+		return false
+	}
+	flagged, err := p.source.Flagged(ctx, ip)
+	if err != nil {
+		log.Printf("IP reputation lookup failed for %s: %v", ip, err)
+		return false
+	}
+	if !flagged {
+		return false
+	}
+	log.Printf("Charge from flagged IP: ip=%s, blocked=%v", ip, p.block)
+	return p.block
+}
+
+// ipBlocklist flags IPs contained in a fixed set of addresses and CIDR ranges
+type ipBlocklist struct {
+	nets []*net.IPNet
+}
+
+// loadIPBlocklist reads one IP or CIDR per line; blank lines and lines starting with # are skipped
+func loadIPBlocklist(path string) (*ipBlocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	list := &ipBlocklist{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "/") {
+			if strings.Contains(line, ":") {
+				line += "/128"
+			} else {
+				line += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(line)
+		if err != nil {
+			return nil, errors.New("invalid IP blocklist entry: " + line)
+		}
+		list.nets = append(list.nets, ipNet)
+	}
+	return list, scanner.Err()
+}
+
+// Flagged reports whether ip falls within any blocklisted range
+func (l *ipBlocklist) Flagged(_ context.Context, ip string) (bool, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false, nil
+	}
+	for _, n := range l.nets {
+		if n.Contains(parsed) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cachedIPReputation memoizes lookups from a slower source, such as a remote provider, for ttl
+type cachedIPReputation struct {
+	source IPReputation
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cachedIPVerdict
+}
+
+type cachedIPVerdict struct {
+	flagged bool
+	expires time.Time
+}
+
+// newCachedIPReputation wraps source with a cache holding each verdict for ttl
+func newCachedIPReputation(source IPReputation, ttl time.Duration) *cachedIPReputation {
+	return &cachedIPReputation{source: source, ttl: ttl, entries: make(map[string]cachedIPVerdict)}
+}
+
+// Flagged returns the cached verdict for ip, consulting the source when it is missing or stale
+func (c *cachedIPReputation) Flagged(ctx context.Context, ip string) (bool, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[ip]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.flagged, nil
+	}
+
+	flagged, err := c.source.Flagged(ctx, ip)
+	if err != nil {
+		return false, err
+	}
+	c.mu.Lock()
+	c.entries[ip] = cachedIPVerdict{flagged: flagged, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return flagged, nil
+}
+
+// cleanup drops verdicts that expired before now, so the cache stays bounded by recent traffic
+func (c *cachedIPReputation) cleanup(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for ip, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, ip)
+		}
+	}
+}
+
+// startCleanup runs cleanup once per ttl for the life of the process
+func (c *cachedIPReputation) startCleanup() {
+	go func() {
+		ticker := time.NewTicker(c.ttl)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			c.cleanup(now)
+		}
+	}()
+}
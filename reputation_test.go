@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeIPReputation flags a fixed set of IPs and counts lookups
+type fakeIPReputation struct {
+	flagged map[string]bool
+	err     error
+	lookups int
+}
+
+func (f *fakeIPReputation) Flagged(_ context.Context, ip string) (bool, error) {
+	f.lookups++
+	return f.flagged[ip], f.err
+}
+
+func TestIPReputationPolicy(t *testing.T) {
+	source := &fakeIPReputation{flagged: map[string]bool{"198.51.100.7": true}}
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		policy *ipReputationPolicy
+		ip     string
+		want   bool
+	}{
+		{"disabled", nil, "198.51.100.7", false},
+		{"block flagged", &ipReputationPolicy{source: source, block: true}, "198.51.100.7", true},
+		{"block clean", &ipReputationPolicy{source: source, block: true}, "203.0.113.1", false},
+		{"flag only", &ipReputationPolicy{source: source, block: false}, "198.51.100.7", false},
+		{"lookup failure fails open", &ipReputationPolicy{source: &fakeIPReputation{err: errors.New("down")}, block: true}, "198.51.100.7", false},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.blocks(ctx, tt.ip); got != tt.want {
+			t.Errorf("%s: blocks() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCachedIPReputation(t *testing.T) {
+	source := &fakeIPReputation{flagged: map[string]bool{"198.51.100.7": true}}
+	cache := newCachedIPReputation(source, time.Hour)
+	ctx := context.Background()
+
+	for range 3 {
+		if flagged, _ := cache.Flagged(ctx, "198.51.100.7"); !flagged {
+			t.Fatal("cached verdict lost")
+		}
+	}
+	if source.lookups != 1 {
+		t.Errorf("source consulted %d times, want 1", source.lookups)
+	}
+
+	cache.ttl = 0
+	cache.Flagged(ctx, "203.0.113.1")
+	cache.Flagged(ctx, "203.0.113.1")
+	if source.lookups != 3 {
+		t.Errorf("source consulted %d times, want stale verdicts to be refreshed", source.lookups)
+	}
+}
+
+func TestCachedIPReputationCleanup(t *testing.T) {
+	cache := newCachedIPReputation(&fakeIPReputation{}, time.Minute)
+	ctx := context.Background()
+	cache.Flagged(ctx, "198.51.100.7")
+	cache.Flagged(ctx, "203.0.113.1")
+
+	cache.cleanup(time.Now())
+	if len(cache.entries) != 2 {
+		t.Errorf("cleanup dropped fresh verdicts, %d left", len(cache.entries))
+	}
+	cache.cleanup(time.Now().Add(time.Minute))
+	if len(cache.entries) != 0 {
+		t.Errorf("cleanup kept %d expired verdicts", len(cache.entries))
+	}
+}
+
+func TestLoadIPBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	err := os.WriteFile(path, []byte("# tor exits\n198.51.100.7\n\n203.0.113.0/24\n2001:db8::1\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := loadIPBlocklist(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"198.51.100.7":  true,
+		"198.51.100.8":  false,
+		"203.0.113.200": true,
+		"2001:db8::1":   true,
+		"2001:db8::2":   false,
+		"not-an-ip":     false,
+	} {
+		if got, _ := list.Flagged(context.Background(), ip); got != want {
+			t.Errorf("Flagged(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("999.1.1.1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadIPBlocklist(path); err == nil {
+		t.Error("an invalid entry should be rejected")
+	}
+}
+
+func TestPaymentBlockedByIPReputation(t *testing.T) {
+	ipReputation = &ipReputationPolicy{source: &fakeIPReputation{flagged: map[string]bool{"192.0.2.1": true}}, block: true}
+	t.Cleanup(func() { ipReputation = nil })
+
+	// httptest requests come from 192.0.2.1
+	rec := postPayment(t, `{}`, nil)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want 403", rec.Code)
+	}
+}
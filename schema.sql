@@ -1,9 +1,45 @@
-CREATE TABLE transactions (
+-- Applied on every startup, so every statement must be idempotent.
+-- Add new columns as ALTER TABLE ... ADD COLUMN IF NOT EXISTS so existing databases pick them up.
+
+CREATE TABLE IF NOT EXISTS transactions (
     id SERIAL PRIMARY KEY,
     token VARCHAR(64) NOT NULL,
-    amount DECIMAL(10,2) NOT NULL,
+    amount_cents BIGINT NOT NULL,
     status VARCHAR(20) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
-CREATE INDEX idx_transactions_created_at ON transactions(created_at);
\ No newline at end of file
+-- Convert the original DECIMAL amount column to integer cents
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'transactions' AND column_name = 'amount') THEN
+        ALTER TABLE transactions ADD COLUMN IF NOT EXISTS amount_cents BIGINT;
+        UPDATE transactions SET amount_cents = ROUND(amount * 100);
+        ALTER TABLE transactions ALTER COLUMN amount_cents SET NOT NULL;
+        ALTER TABLE transactions DROP COLUMN amount;
+    END IF;
+END $$;
+
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS device_fingerprint VARCHAR(128) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS idempotency_key VARCHAR(255);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS last4 VARCHAR(4) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES transactions(id);
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD';
+-- tax_id holds AES-GCM ciphertext; the plaintext never reaches the database
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tax_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tax_id_country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS billing_country VARCHAR(2) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS geo_mismatch BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS mcc VARCHAR(4) NOT NULL DEFAULT '';
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS api_key_hash VARCHAR(64) NOT NULL DEFAULT '';
+
+-- Idempotency keys are unique per API key, replacing the original table-wide constraint
+ALTER TABLE transactions DROP CONSTRAINT IF EXISTS transactions_idempotency_key_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_transactions_idempotency_key ON transactions(api_key_hash, idempotency_key);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at ON transactions(created_at);
+CREATE INDEX IF NOT EXISTS idx_transactions_parent_id ON transactions(parent_id);
+CREATE INDEX IF NOT EXISTS idx_transactions_created_at_id ON transactions(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_transactions_tags ON transactions USING GIN (tags);
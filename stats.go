@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// processorStats accumulates processor outcomes between periodic summary log lines
+type processorStats struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	approved  int
+	declined  int
+}
+
+// procStats is nil unless PROCESSOR_STATS_INTERVAL is set
+var procStats *processorStats
+
+// record adds a single processor outcome to the current interval
+func (s *processorStats) record(latency time.Duration, success bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latencies = append(s.latencies, latency)
+	if success {
+		s.approved++
+	} else {
+		s.declined++
+	}
+}
+
+// logSummary logs latency percentiles and outcome counts for the interval, then resets them
+func (s *processorStats) logSummary(interval time.Duration) {
+	s.mu.Lock()
+	latencies, approved, declined := s.latencies, s.approved, s.declined
+	s.latencies, s.approved, s.declined = nil, 0, 0
+	s.mu.Unlock()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	log.Printf("Processor stats: interval=%v, approved=%d, declined=%d, p50=%v, p90=%v, p99=%v",
+		interval, approved, declined,
+		percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99))
+}
+
+// percentile returns the p-th percentile of sorted latencies, or zero when there are none
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// startProcessorStatsLogger enables stats collection and logs a summary every interval
+func startProcessorStatsLogger(interval time.Duration) {
+	procStats = &processorStats{}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			procStats.logSummary(interval)
+		}
+	}()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessorStatsSummary(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	s := &processorStats{}
+	for i, ms := range []int{10, 20, 30, 40} {
+		s.record(time.Duration(ms)*time.Millisecond, i != 3)
+	}
+	s.logSummary(time.Minute)
+
+	out := buf.String()
+	for _, want := range []string{"approved=3", "declined=1", "p50=20ms", "p99=30ms"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("summary %q does not contain %q", out, want)
+		}
+	}
+
+	buf.Reset()
+	s.logSummary(time.Minute)
+	if !strings.Contains(buf.String(), "approved=0, declined=0") {
+		t.Errorf("summary after reset = %q, want zero counts", buf.String())
+	}
+}
+
+func TestProcessorStatsNilSafe(t *testing.T) {
+	var s *processorStats
+	s.record(time.Millisecond, true) // must not panic when stats are disabled
+}
+
+func TestPercentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	tests := []struct {
+		p    int
+		want time.Duration
+	}{{50, 5}, {90, 9}, {99, 9}, {100, 10}}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("percentile(p%d) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile(nil) = %v, want 0", got)
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+)
+
+const (
+	maxTags      = 10
+	maxTagLength = 50
+)
+
+var tagPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9:._-]*$`)
+
+// validateTag checks a single tag such as "black-friday" or "channel:mobile"
+func validateTag(tag string) bool {
+	return len(tag) <= maxTagLength && tagPattern.MatchString(tag)
+}
+
+// normalizeTags validates optional transaction tags and drops duplicates, always returning a non-nil slice
+func normalizeTags(tags []string) ([]string, bool) {
+	if len(tags) > maxTags {
+		return nil, false
+	}
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !validateTag(tag) {
+			return nil, false
+		}
+		if !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	tests := []struct {
+		name   string
+		in     []string
+		want   []string
+		wantOK bool
+	}{
+		{"none", nil, []string{}, true},
+		{"valid", []string{"black-friday", "channel:mobile"}, []string{"black-friday", "channel:mobile"}, true},
+		{"duplicates dropped", []string{"a", "b", "a"}, []string{"a", "b"}, true},
+		{"empty tag", []string{""}, nil, false},
+		{"space", []string{"black friday"}, nil, false},
+		{"too long", []string{strings.Repeat("x", maxTagLength+1)}, nil, false},
+		{"too many", strings.Split("a b c d e f g h i j k", " "), nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := normalizeTags(tt.in)
+			if ok != tt.wantOK || !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeTags(%q) = %q, %v; want %q, %v", tt.in, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestListTransactionsFiltersByTags(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+
+	for _, tags := range []string{`["black-friday","channel:mobile"]`, `["black-friday"]`, `["channel:mobile"]`} {
+		body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":5.00,"tags":` + tags + `}`
+		if rec := postPayment(t, body, nil); rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"?tag=black-friday", []int{2, 1}},
+		{"?tag=channel:mobile", []int{3, 1}},
+		{"?tag=black-friday&tag=channel:mobile", []int{1}},
+		{"?tag=missing", []int{}},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handleListTransactions(rec, httptest.NewRequest(http.MethodGet, "/api/transactions"+tt.query, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", tt.query, rec.Code)
+		}
+		var resp TransactionListResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		ids := []int{}
+		for _, txn := range resp.Data {
+			ids = append(ids, txn.ID)
+		}
+		if !slices.Equal(ids, tt.want) || resp.Total != len(tt.want) {
+			t.Errorf("%s: ids = %v total %d, want %v", tt.query, ids, resp.Total, tt.want)
+		}
+	}
+}
+
+func TestListTransactionsRejectsInvalidTag(t *testing.T) {
+	rec := httptest.NewRecorder()
+	handleListTransactions(rec, httptest.NewRequest(http.MethodGet, "/api/transactions?tag=bad%20tag", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
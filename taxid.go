@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const maxTaxIDLength = 64
+
+// taxIDPatterns maps ISO 3166 alpha-2 country codes to the tax ID format required for that country
+var taxIDPatterns = map[string]*regexp.Regexp{}
+
+// loadTaxIDPatterns parses TAX_ID_PATTERNS, a semicolon-separated list of country=regexp pairs such as
+// "DE=^DE[0-9]{9}$;US=^[0-9]{2}-?[0-9]{7}$". Semicolons separate entries so patterns may contain commas.
+func loadTaxIDPatterns(patterns string) (map[string]*regexp.Regexp, error) {
+	table := make(map[string]*regexp.Regexp)
+	for _, entry := range strings.Split(patterns, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		country, pattern, ok := strings.Cut(entry, "=")
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !ok || len(country) != 2 {
+			return nil, errors.New("invalid tax ID pattern entry: " + entry)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.New("invalid tax ID pattern for " + country + ": " + err.Error())
+		}
+		table[country] = re
+	}
+	return table, nil
+}
+
+// validateTaxID loosely checks an optional tax ID, enforcing the configured format for its country if there is one.
+// The country only qualifies a tax ID, so a country without one is rejected.
+func validateTaxID(taxID, country string) bool {
+	if taxID == "" {
+		return country == ""
+	}
+	if len(taxID) > maxTaxIDLength {
+		return false
+	}
+	if matched, _ := regexp.MatchString(`^[A-Za-z0-9 ./-]+$`, taxID); !matched {
+		return false
+	}
+	if country == "" {
+		return true
+	}
+	if !iso3166Alpha2[country] {
+		return false
+	}
+	if re, ok := taxIDPatterns[country]; ok {
+		return re.MatchString(taxID)
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestValidateTaxID(t *testing.T) {
+	taxIDPatterns = map[string]*regexp.Regexp{"DE": regexp.MustCompile(`^DE[0-9]{9}$`)}
+	t.Cleanup(func() { taxIDPatterns = map[string]*regexp.Regexp{} })
+
+	tests := []struct {
+		name    string
+		taxID   string
+		country string
+		want    bool
+	}{
+		{"none", "", "", true},
+		{"without country", "12-3456789", "", true},
+		{"matches country pattern", "DE123456789", "DE", true},
+		{"country without pattern", "12-3456789", "US", true},
+		{"fails country pattern", "DE12345", "DE", false},
+		{"unknown country", "12-3456789", "ZZ", false},
+		{"country without tax ID", "", "DE", false},
+		{"bad characters", "DE<script>", "", false},
+		{"too long", strings.Repeat("1", maxTaxIDLength+1), "", false},
+	}
+	for _, tt := range tests {
+		if got := validateTaxID(tt.taxID, tt.country); got != tt.want {
+			t.Errorf("%s: validateTaxID(%q, %q) = %v, want %v", tt.name, tt.taxID, tt.country, got, tt.want)
+		}
+	}
+}
+
+func TestLoadTaxIDPatterns(t *testing.T) {
+	table, err := loadTaxIDPatterns("de=^DE[0-9]{9}$; US=^[0-9]{2}-?[0-9]{7}$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table) != 2 || table["DE"] == nil || table["US"] == nil {
+		t.Errorf("patterns = %v", table)
+	}
+	for _, bad := range []string{"DEU=^x$", "DE", "DE=("} {
+		if _, err := loadTaxIDPatterns(bad); err == nil {
+			t.Errorf("loadTaxIDPatterns(%q) should fail", bad)
+		}
+	}
+}
+
+func TestPaymentRejectsTaxIDCountryWithoutTaxID(t *testing.T) {
+	setTestSecrets(t)
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"tax_id_country":"DE"}`
+	rec := postPayment(t, body, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "Tax ID country requires a tax ID") {
+		t.Errorf("status = %d, body %q", rec.Code, rec.Body)
+	}
+}
+
+func TestTaxIDOnReceipt(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	body := `{"card_number":"4242424242424242","expiry":"` + futureExpiry() + `","cvv":"123","amount":10.00,"tax_id":" de123456789 ","tax_id_country":"de"}`
+	resp := createPayment(t, body, nil)
+
+	var stored string
+	if err := db.QueryRow("SELECT tax_id FROM transactions WHERE id = $1", resp.TransactionID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == "" || strings.Contains(stored, "123456789") {
+		t.Errorf("stored tax_id = %q, want ciphertext", stored)
+	}
+
+	rec := httptest.NewRecorder()
+	handleReceipt(rec, httptest.NewRequest(http.MethodGet, resp.ReceiptURL, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("receipt status = %d, body %s", rec.Code, rec.Body)
+	}
+	var receipt ReceiptResponse
+	if err := json.NewDecoder(rec.Body).Decode(&receipt); err != nil {
+		t.Fatal(err)
+	}
+	if receipt.TaxID != "de123456789" || receipt.TaxIDCountry != "DE" {
+		t.Errorf("receipt tax_id = %q, tax_id_country = %q", receipt.TaxID, receipt.TaxIDCountry)
+	}
+
+	// Payments without a tax ID leave it off the receipt
+	resp = createPayment(t, testPaymentBody("4242424242424242", "5.00"), nil)
+	rec = httptest.NewRecorder()
+	handleReceipt(rec, httptest.NewRequest(http.MethodGet, resp.ReceiptURL, nil))
+	if strings.Contains(rec.Body.String(), "tax_id") {
+		t.Errorf("receipt without a tax ID = %s", rec.Body)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestTokenizeCardRoundTrip(t *testing.T) {
+	setTestSecrets(t)
+	for _, card := range []string{"4242424242424242", "4242 4242 4242 4242", "378282246310005", "4242424242424242428"} {
+		token := tokenizeCard(card)
+		if len(token) > 64 {
+			t.Errorf("token for %q is %d characters, over the column's 64", card, len(token))
+		}
+		got, err := detokenizeCard(token)
+		if err != nil || got != normalizeCardNumber(card) {
+			t.Errorf("detokenizeCard(tokenizeCard(%q)) = %q, %v", card, got, err)
+		}
+	}
+	if tokenizeCard("4242424242424242") == tokenizeCard("4242424242424242") {
+		t.Error("tokens for the same card should differ")
+	}
+}
+
+func TestDetokenizeCardRejectsTampering(t *testing.T) {
+	setTestSecrets(t)
+	token := tokenizeCard("4242424242424242")
+	sealed, _ := base64.RawURLEncoding.DecodeString(token)
+	sealed[len(sealed)-1] ^= 1
+	flipped := base64.RawURLEncoding.EncodeToString(sealed)
+
+	for name, bad := range map[string]string{
+		"flipped bit": flipped,
+		"truncated":   token[:10],
+		"not base64":  "!!!",
+		"empty":       "",
+	} {
+		if _, err := detokenizeCard(bad); err != errInvalidToken {
+			t.Errorf("%s: err = %v, want errInvalidToken", name, err)
+		}
+	}
+}
+
+func TestTokenEncryptionKeyIsNotSecretKey(t *testing.T) {
+	setTestSecrets(t)
+	token := tokenizeCard("4242424242424242")
+	encryptedTaxID := encryptPII("DE123456789")
+
+	// Rotating SECRET_KEY, which clients and webhook receivers hold, must not affect decryption
+	t.Setenv("SECRET_KEY", "rotated")
+	if card, err := detokenizeCard(token); err != nil || card != "4242424242424242" {
+		t.Errorf("detokenizeCard() = %q, %v after changing SECRET_KEY", card, err)
+	}
+	t.Setenv("TOKEN_ENCRYPTION_KEY", "another-key")
+	if _, err := detokenizeCard(token); err != errInvalidToken {
+		t.Errorf("err = %v, want a token from another encryption key to be rejected", err)
+	}
+	if _, err := decryptPII(encryptedTaxID); err == nil {
+		t.Error("PII from another encryption key should not decrypt")
+	}
+}
+
+func TestEncryptPIIRoundTrip(t *testing.T) {
+	setTestSecrets(t)
+	encrypted := encryptPII("DE123456789")
+	if strings.Contains(encrypted, "DE123456789") {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+	if got, err := decryptPII(encrypted); err != nil || got != "DE123456789" {
+		t.Errorf("decryptPII() = %q, %v", got, err)
+	}
+}
+
+func TestTokenNotExposed(t *testing.T) {
+	txn, err := json.Marshal(Transaction{ID: 1, Token: "secret-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := json.Marshal(WebhookEvent{TransactionID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range []string{string(txn), string(event)} {
+		if strings.Contains(out, "token") {
+			t.Errorf("%s exposes the card token", out)
+		}
+	}
+}
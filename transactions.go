@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TransactionListResponse defines the structure for paginated transaction listings
+type TransactionListResponse struct {
+	Data       []Transaction `json:"data"`
+	Total      int           `json:"total"`
+	Limit      int           `json:"limit"`
+	Offset     int           `json:"offset"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// transactionColumns lists the columns read by scanTransaction, in order
+const transactionColumns = "id, token, amount_cents, status, created_at, user_agent, device_fingerprint, last4, parent_id, currency, billing_country, geo_mismatch, tags, mcc"
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+)
+
+var errCursorInvalid = errors.New("invalid cursor")
+
+// listCursor is the (created_at, id) position of the last row on a page; later pages start strictly after it
+type listCursor struct {
+	createdAt time.Time
+	id        int
+}
+
+// encodeListCursor returns an opaque, signed cursor so clients cannot fabricate positions
+func encodeListCursor(c listCursor) string {
+	payload := strconv.FormatInt(c.createdAt.UnixMicro(), 10) + "." + strconv.Itoa(c.id)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signCursor(payload))
+}
+
+// decodeListCursor verifies a cursor produced by encodeListCursor
+func decodeListCursor(cursor string) (listCursor, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return listCursor{}, errCursorInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return listCursor{}, errCursorInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, signCursor(string(payload))) {
+		return listCursor{}, errCursorInvalid
+	}
+
+	createdPart, idPart, ok := strings.Cut(string(payload), ".")
+	if !ok {
+		return listCursor{}, errCursorInvalid
+	}
+	micros, err := strconv.ParseInt(createdPart, 10, 64)
+	if err != nil {
+		return listCursor{}, errCursorInvalid
+	}
+	id, err := strconv.Atoi(idPart)
+	if err != nil {
+		return listCursor{}, errCursorInvalid
+	}
+	return listCursor{createdAt: time.UnixMicro(micros).UTC(), id: id}, nil
+}
+
+// signCursor computes the HMAC of a cursor payload, domain-separated from other uses of INTERNAL_SIGNING_KEY
+func signCursor(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(internalSigningKey))
+	mac.Write([]byte("cursor:" + payload))
+	return mac.Sum(nil)
+}
+
+// handleListTransactions returns stored transactions, newest first, paginated by limit and either offset
+// or the cursor from a previous page. Cursors stay stable when new rows are inserted during pagination.
+// Repeated ?tag= parameters restrict the list to transactions carrying all of the given tags.
+func handleListTransactions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	limit := defaultListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxListLimit)
+	}
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			http.Error(w, "Invalid offset", http.StatusBadRequest)
+			return
+		}
+	}
+	var after *listCursor
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if offset != 0 {
+			http.Error(w, "Cursor cannot be combined with offset", http.StatusBadRequest)
+			return
+		}
+		c, err := decodeListCursor(v)
+		if err != nil {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		after = &c
+	}
+
+	var filter string
+	var args []any
+	if tags := r.URL.Query()["tag"]; len(tags) > 0 {
+		for _, tag := range tags {
+			if !validateTag(tag) {
+				http.Error(w, "Invalid tag", http.StatusBadRequest)
+				return
+			}
+		}
+		filter = " WHERE tags @> $1"
+		args = append(args, pq.Array(tags))
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), dbTimeout)
+	defer cancel()
+
+	resp := TransactionListResponse{Data: []Transaction{}, Limit: limit, Offset: offset}
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM transactions"+filter, args...).Scan(&resp.Total)
+	if isDBTimeout(err) {
+		log.Printf("Database timeout counting transactions: %v", err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to count transactions: %v", err)
+		http.Error(w, "Failed to list transactions", http.StatusInternalServerError)
+		return
+	}
+
+	query := "SELECT " + transactionColumns + " FROM transactions" + filter
+	if after != nil {
+		keyset := fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)+1, len(args)+2)
+		if filter == "" {
+			query += " WHERE " + keyset
+		} else {
+			query += " AND " + keyset
+		}
+		args = append(args, after.createdAt, after.id)
+	}
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if isDBTimeout(err) {
+		log.Printf("Database timeout listing transactions: %v", err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list transactions: %v", err)
+		http.Error(w, "Failed to list transactions", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var txn Transaction
+		if err := scanTransaction(rows, &txn); err != nil {
+			log.Printf("Failed to read transaction: %v", err)
+			http.Error(w, "Failed to list transactions", http.StatusInternalServerError)
+			return
+		}
+		resp.Data = append(resp.Data, txn)
+	}
+	err = rows.Err()
+	if isDBTimeout(err) {
+		log.Printf("Database timeout listing transactions: %v", err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list transactions: %v", err)
+		http.Error(w, "Failed to list transactions", http.StatusInternalServerError)
+		return
+	}
+	if len(resp.Data) == limit {
+		last := resp.Data[len(resp.Data)-1]
+		resp.NextCursor = encodeListCursor(listCursor{createdAt: last.CreatedAt, id: last.ID})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleGetTransaction returns a single stored transaction by ID
+func handleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/transactions/"))
+	if err != nil || id <= 0 {
+		http.Error(w, "Invalid transaction ID", http.StatusBadRequest)
+		return
+	}
+
+	txn, err := getTransaction(r.Context(), id)
+	if isDBTimeout(err) {
+		log.Printf("Database timeout loading transaction %d: %v", id, err)
+		http.Error(w, "Database timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load transaction %d: %v", id, err)
+		http.Error(w, "Failed to load transaction", http.StatusInternalServerError)
+		return
+	}
+	if txn == nil {
+		http.Error(w, "Transaction not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(txn)
+}
+
+// getTransaction loads a transaction by ID, returning nil if it does not exist
+func getTransaction(ctx context.Context, id int) (*Transaction, error) {
+	ctx, cancel := context.WithTimeout(ctx, dbTimeout)
+	defer cancel()
+	var txn Transaction
+	err := scanTransaction(db.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = $1", id), &txn)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &txn, nil
+}
+
+// scanTransaction reads a row selected with transactionColumns into txn
+func scanTransaction(row interface{ Scan(...any) error }, txn *Transaction) error {
+	var parentID sql.NullInt64
+	err := row.Scan(&txn.ID, &txn.Token, &txn.Amount, &txn.Status, &txn.CreatedAt, &txn.UserAgent, &txn.DeviceFingerprint, &txn.Last4, &parentID, &txn.Currency, &txn.BillingCountry, &txn.GeoMismatch, pq.Array(&txn.Tags), &txn.MCC)
+	if err != nil {
+		return err
+	}
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		txn.ParentID = &id
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getTransactionResponse calls handleGetTransaction for path
+func getTransactionResponse(path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handleGetTransaction(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestGetTransactionRejectsMalformedID(t *testing.T) {
+	for _, path := range []string{"/api/transactions/abc", "/api/transactions/0", "/api/transactions/-1", "/api/transactions/"} {
+		if rec := getTransactionResponse(path); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", path, rec.Code)
+		}
+	}
+}
+
+func TestGetTransaction(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	created := createPayment(t, testPaymentBody("4242424242424242", "12.34"), nil)
+
+	rec := getTransactionResponse("/api/transactions/1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var txn Transaction
+	if err := json.NewDecoder(rec.Body).Decode(&txn); err != nil {
+		t.Fatal(err)
+	}
+	if txn.ID != created.TransactionID || txn.Amount != 1234 || txn.Last4 != "4242" || txn.Status != "success" {
+		t.Errorf("transaction = %+v", txn)
+	}
+
+	if rec := getTransactionResponse("/api/transactions/999"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing transaction: status = %d, want 404", rec.Code)
+	}
+}
+
+// listTransactions calls handleListTransactions with query and decodes the page
+func listTransactions(t *testing.T, query string) TransactionListResponse {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleListTransactions(rec, httptest.NewRequest(http.MethodGet, "/api/transactions"+query, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status = %d, body %s", query, rec.Code, rec.Body)
+	}
+	var resp TransactionListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// transactionIDs returns the IDs on a page, in order
+func transactionIDs(resp TransactionListResponse) []int {
+	ids := []int{}
+	for _, txn := range resp.Data {
+		ids = append(ids, txn.ID)
+	}
+	return ids
+}
+
+func TestListTransactionsPagination(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	for range 5 {
+		createPayment(t, testPaymentBody("4242424242424242", "1.00"), nil)
+	}
+
+	tests := []struct {
+		query     string
+		wantIDs   []int
+		wantLimit int
+	}{
+		{"", []int{5, 4, 3, 2, 1}, defaultListLimit},
+		{"?limit=2", []int{5, 4}, 2},
+		{"?limit=2&offset=2", []int{3, 2}, 2},
+		{"?offset=10", []int{}, defaultListLimit},
+		{"?limit=100000", []int{5, 4, 3, 2, 1}, maxListLimit},
+	}
+	for _, tt := range tests {
+		resp := listTransactions(t, tt.query)
+		if ids := transactionIDs(resp); !slices.Equal(ids, tt.wantIDs) {
+			t.Errorf("%q: ids = %v, want %v", tt.query, ids, tt.wantIDs)
+		}
+		if resp.Total != 5 || resp.Limit != tt.wantLimit {
+			t.Errorf("%q: total = %d limit = %d, want 5 and %d", tt.query, resp.Total, resp.Limit, tt.wantLimit)
+		}
+	}
+}
+
+func TestListTransactionsRejectsBadParams(t *testing.T) {
+	for _, query := range []string{"?limit=0", "?limit=-1", "?limit=abc", "?offset=-1", "?offset=abc"} {
+		rec := httptest.NewRecorder()
+		handleListTransactions(rec, httptest.NewRequest(http.MethodGet, "/api/transactions"+query, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestListCursorRoundTrip(t *testing.T) {
+	setTestSecrets(t)
+	want := listCursor{createdAt: time.Date(2026, 3, 1, 12, 30, 0, 123456000, time.UTC), id: 42}
+	got, err := decodeListCursor(encodeListCursor(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("decoded %+v, want %+v in UTC", got, want)
+	}
+}
+
+func TestListCursorRejectsTampering(t *testing.T) {
+	setTestSecrets(t)
+	cursor := encodeListCursor(listCursor{createdAt: time.Now(), id: 42})
+	payload, sig, _ := strings.Cut(cursor, ".")
+	forgedPayload := base64.RawURLEncoding.EncodeToString([]byte("0.99999"))
+
+	for _, bad := range []string{
+		forgedPayload + "." + sig,
+		payload + "." + base64.RawURLEncoding.EncodeToString(hmacSHA256("test-secret", "cursor:0.99999")),
+		payload,
+		"!!!.???",
+	} {
+		if _, err := decodeListCursor(bad); err != errCursorInvalid {
+			t.Errorf("decodeListCursor(%q) err = %v, want errCursorInvalid", bad, err)
+		}
+		rec := httptest.NewRecorder()
+		handleListTransactions(rec, httptest.NewRequest(http.MethodGet, "/api/transactions?cursor="+bad, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("cursor %q: status = %d, want 400", bad, rec.Code)
+		}
+	}
+}
+
+// hmacSHA256 signs msg with key, as a client holding only SECRET_KEY might try to forge a cursor
+func hmacSHA256(key, msg string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func TestListTransactionsCursorStableAcrossInserts(t *testing.T) {
+	openTestDB(t)
+	setTestSecrets(t)
+	for range 4 {
+		createPayment(t, testPaymentBody("4242424242424242", "1.00"), nil)
+	}
+
+	first := listTransactions(t, "?limit=2")
+	if ids := transactionIDs(first); !slices.Equal(ids, []int{4, 3}) || first.NextCursor == "" {
+		t.Fatalf("first page = %v, cursor %q", ids, first.NextCursor)
+	}
+
+	// A payment arriving between pages would shift an offset-based second page
+	createPayment(t, testPaymentBody("4242424242424242", "1.00"), nil)
+
+	second := listTransactions(t, "?limit=2&cursor="+url.QueryEscape(first.NextCursor))
+	if ids := transactionIDs(second); !slices.Equal(ids, []int{2, 1}) {
+		t.Errorf("second page = %v, want [2 1]", ids)
+	}
+}
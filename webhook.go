@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebhookEvent defines the structure of payment outcome callbacks
+type WebhookEvent struct {
+	Event         string    `json:"event"`
+	TransactionID int       `json:"transaction_id"`
+	Amount        Cents     `json:"amount"`
+	Currency      string    `json:"currency"`
+	GeoMismatch   bool      `json:"geo_mismatch"` // fraud signal: card issued outside the billing country
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+const (
+	webhookMaxAttempts = 4
+	webhookBackoff     = 500 * time.Millisecond
+	webhookTimeout     = 10 * time.Second
+)
+
+// webhookURL receives payment events when set via WEBHOOK_URL
+var webhookURL string
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// pendingWebhooks tracks deliveries still in progress so shutdown can wait for them
+var pendingWebhooks sync.WaitGroup
+
+// sendPaymentWebhook delivers the payment's outcome in the background; delivery never affects the payment
+func sendPaymentWebhook(txn *Transaction, success bool) {
+	if webhookURL == "" {
+		return
+	}
+	event := WebhookEvent{
+		Event:         "payment.failed",
+		TransactionID: txn.ID,
+		Amount:        txn.Amount,
+		Currency:      txn.Currency,
+		GeoMismatch:   txn.GeoMismatch,
+		Timestamp:     time.Now().UTC(),
+	}
+	if success {
+		event.Event = "payment.succeeded"
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode webhook for transaction %d: %v", txn.ID, err)
+		return
+	}
+
+	pendingWebhooks.Add(1)
+	go func() {
+		defer pendingWebhooks.Done()
+		deliverWebhook(event, body)
+	}()
+}
+
+// deliverWebhook posts body to webhookURL, retrying failures with exponential backoff
+func deliverWebhook(event WebhookEvent, body []byte) {
+	signature := signBody(body)
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err := postWebhook(body, signature)
+		if err == nil {
+			return
+		}
+		if attempt == webhookMaxAttempts {
+			log.Printf("Webhook delivery failed: event=%s, transaction_id=%d, attempts=%d, err=%v",
+				event.Event, event.TransactionID, attempt, err)
+			return
+		}
+		log.Printf("Webhook attempt %d failed for transaction %d, retrying in %v: %v",
+			attempt, event.TransactionID, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postWebhook makes a single delivery attempt; any non-2xx response counts as a failure
+func postWebhook(body []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Signature", signature)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// webhookReceiver starts a server that fails the first failures deliveries and records the rest
+func webhookReceiver(t *testing.T, failures int32) (received chan *http.Request, bodies chan []byte, attempts *atomic.Int32) {
+	t.Helper()
+	received = make(chan *http.Request, 10)
+	bodies = make(chan []byte, 10)
+	attempts = new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+	}))
+	t.Cleanup(srv.Close)
+	webhookURL = srv.URL
+	t.Cleanup(func() { webhookURL = "" })
+	return received, bodies, attempts
+}
+
+func TestPaymentWebhookSigned(t *testing.T) {
+	setTestSecrets(t)
+	received, bodies, _ := webhookReceiver(t, 0)
+
+	sendPaymentWebhook(&Transaction{ID: 7, Amount: 1999, Currency: "EUR"}, true)
+	pendingWebhooks.Wait()
+
+	r, body := <-received, <-bodies
+	if !validateSignature(body, r.Header.Get("X-Signature")) {
+		t.Error("webhook signature does not verify against the body")
+	}
+	var event WebhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Event != "payment.succeeded" || event.TransactionID != 7 || event.Amount != 1999 || event.Currency != "EUR" {
+		t.Errorf("event = %+v", event)
+	}
+}
+
+func TestPaymentWebhookRetries(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for webhook backoff")
+	}
+	setTestSecrets(t)
+	received, _, attempts := webhookReceiver(t, 2)
+
+	start := time.Now()
+	sendPaymentWebhook(&Transaction{ID: 8, Amount: 500, Currency: "USD"}, false)
+	pendingWebhooks.Wait()
+
+	select {
+	case <-received:
+	default:
+		t.Fatal("webhook was not delivered after transient failures")
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if elapsed := time.Since(start); elapsed < webhookBackoff*3 {
+		t.Errorf("retries took %v, want backoff of at least %v", elapsed, webhookBackoff*3)
+	}
+}
+
+func TestPaymentWebhookDisabled(t *testing.T) {
+	webhookURL = ""
+	sendPaymentWebhook(&Transaction{ID: 9}, true)
+	pendingWebhooks.Wait()
+}